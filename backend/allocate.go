@@ -0,0 +1,40 @@
+package backend
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	errSubnetNotIPv4 = "the subnet %s is not an ipv4 subnet"
+	errSubnetFull    = "no free address left in %s"
+)
+
+// AllocateIP returns the lowest address of subnet not used by any of the
+// peers, the network and broadcast addresses are never returned
+func AllocateIP(subnet *net.IPNet, peers []Peer) (net.IP, error) {
+	base := subnet.IP.To4()
+	ones, bits := subnet.Mask.Size()
+	if base == nil || bits != 32 {
+		return nil, fmt.Errorf(errSubnetNotIPv4, subnet)
+	}
+
+	taken := map[string]bool{}
+	for _, p := range peers {
+		if p.IP != nil {
+			taken[p.IP.String()] = true
+		}
+	}
+
+	first := binary.BigEndian.Uint32(base.Mask(subnet.Mask))
+	size := uint32(1) << uint(32-ones)
+	for n := uint32(1); n+1 < size; n++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, first+n)
+		if !taken[ip.String()] {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf(errSubnetFull, subnet)
+}
@@ -0,0 +1,36 @@
+package backend
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllocateIP(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("10.0.0.0/24")
+	require.NoError(t, err)
+
+	ip, err := AllocateIP(subnet, []Peer{})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ip.String())
+
+	// the first hole is used
+	ip, err = AllocateIP(subnet, []Peer{testPeer(1), testPeer(2), testPeer(4)})
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.3", ip.String())
+}
+
+func TestAllocateIPSubnetFull(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("10.0.0.0/30")
+	require.NoError(t, err)
+
+	_, err = AllocateIP(subnet, []Peer{testPeer(1), testPeer(2)})
+	assert.Error(t, err)
+
+	_, subnet, err = net.ParseCIDR("fd00::/64")
+	require.NoError(t, err)
+	_, err = AllocateIP(subnet, []Peer{})
+	assert.Error(t, err)
+}
@@ -1,7 +1,25 @@
 package backend
 
-// Backend ...
+import (
+	"context"
+	"time"
+)
+
+// Backend stores the peers of the networks, the ifname of its methods is the
+// name of the network
 type Backend interface {
-	Join(ifname string, peer Peer) error
-	GetPeers(ifname string) ([]Peer, error)
+	Join(ctx context.Context, ifname string, peer Peer) error
+	GetPeers(ctx context.Context, ifname string) ([]Peer, error)
+	// Leave removes the peer with the given public key, removing a peer
+	// that is not there is not an error
+	Leave(ctx context.Context, ifname string, publicKey []byte) error
+}
+
+// withTimeout derives the context for a single backend call, a zero timeout
+// only inherits the deadline and the cancellation of the parent.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
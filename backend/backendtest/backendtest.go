@@ -0,0 +1,228 @@
+// Package backendtest provides a conformance suite that every
+// backend.Backend implementation is expected to pass.
+//
+// A new backend only needs a test that starts (or fakes) its storage and
+// hands the backend to Run:
+//
+//	func TestMyBackend(t *testing.T) {
+//		b := newMyBackend(t)
+//		backendtest.Run(t, b)
+//	}
+package backendtest
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+
+	"wirey/backend"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run executes the conformance suite against b. The backend must be empty
+// when Run is called, every test uses its own interface names so they can
+// share the same storage.
+func Run(t *testing.T, b backend.Backend) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, b backend.Backend)
+	}{
+		{"GetPeersEmpty", testGetPeersEmpty},
+		{"JoinGetPeers", testJoinGetPeers},
+		{"JoinMultiplePeers", testJoinMultiplePeers},
+		{"JoinOverwrites", testJoinOverwrites},
+		{"IfnameIsolation", testIfnameIsolation},
+		{"Leave", testLeave},
+		{"LeaveMissingPeer", testLeaveMissingPeer},
+		{"CancelledContext", testCancelledContext},
+		{"Settings", testSettings},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, b)
+		})
+	}
+}
+
+// NewPeer returns a valid peer with a public key derived from n, formatted
+// the same way `wg pubkey` formats it.
+func NewPeer(n int) backend.Peer {
+	key := make([]byte, 32)
+	key[0] = byte(n)
+	key[1] = byte(n >> 8)
+	// encodes to "////", keys containing slashes must be stored safely
+	key[27], key[28], key[29] = 0xff, 0xff, 0xff
+
+	ip := net.IPv4(10, 0, byte(n>>8), byte(n))
+	return backend.Peer{
+		PublicKey: []byte(fmt.Sprintf("%s\n", base64.StdEncoding.EncodeToString(key))),
+		Endpoint:  fmt.Sprintf("192.168.0.%d:2345", n%256),
+		IP:        &ip,
+	}
+}
+
+func testGetPeersEmpty(t *testing.T, b backend.Backend) {
+	peers, err := b.GetPeers(context.Background(), "empty0")
+	require.NoError(t, err)
+	assert.NotNil(t, peers, "an ifname without peers must return an empty list, not nil")
+	assert.Len(t, peers, 0)
+}
+
+func testJoinGetPeers(t *testing.T, b backend.Backend) {
+	ctx := context.Background()
+	p := NewPeer(1)
+	p.AllowedIPs = []string{"10.1.0.0/16", "10.2.0.0/16"}
+	p.Name = "phone-1"
+	p.Static = true
+
+	require.NoError(t, b.Join(ctx, "join0", p))
+
+	peers, err := b.GetPeers(ctx, "join0")
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	AssertPeerEqual(t, p, peers[0])
+}
+
+func testJoinMultiplePeers(t *testing.T, b backend.Backend) {
+	ctx := context.Background()
+	expected := []backend.Peer{NewPeer(1), NewPeer(2), NewPeer(3)}
+	for _, p := range expected {
+		require.NoError(t, b.Join(ctx, "multi0", p))
+	}
+
+	peers, err := b.GetPeers(ctx, "multi0")
+	require.NoError(t, err)
+	AssertPeersEqual(t, expected, peers)
+}
+
+func testJoinOverwrites(t *testing.T, b backend.Backend) {
+	ctx := context.Background()
+	p := NewPeer(1)
+	require.NoError(t, b.Join(ctx, "overwrite0", p))
+
+	p.Endpoint = "192.168.1.1:4567"
+	p.AllowedIPs = []string{"10.3.0.0/16"}
+	require.NoError(t, b.Join(ctx, "overwrite0", p))
+
+	peers, err := b.GetPeers(ctx, "overwrite0")
+	require.NoError(t, err)
+	require.Len(t, peers, 1, "joining twice with the same public key must replace the peer")
+	AssertPeerEqual(t, p, peers[0])
+}
+
+func testIfnameIsolation(t *testing.T, b backend.Backend) {
+	ctx := context.Background()
+	// wg9 is a prefix of wg99, backends must not mix them up
+	require.NoError(t, b.Join(ctx, "wg9", NewPeer(1)))
+	require.NoError(t, b.Join(ctx, "wg99", NewPeer(2)))
+	require.NoError(t, b.Join(ctx, "wg99", NewPeer(3)))
+
+	peers, err := b.GetPeers(ctx, "wg9")
+	require.NoError(t, err)
+	AssertPeersEqual(t, []backend.Peer{NewPeer(1)}, peers)
+
+	peers, err = b.GetPeers(ctx, "wg99")
+	require.NoError(t, err)
+	AssertPeersEqual(t, []backend.Peer{NewPeer(2), NewPeer(3)}, peers)
+}
+
+func testLeave(t *testing.T, b backend.Backend) {
+	ctx := context.Background()
+	require.NoError(t, b.Join(ctx, "leave0", NewPeer(1)))
+	require.NoError(t, b.Join(ctx, "leave0", NewPeer(2)))
+	require.NoError(t, b.Join(ctx, "leave00", NewPeer(1)))
+
+	require.NoError(t, b.Leave(ctx, "leave0", NewPeer(1).PublicKey))
+
+	peers, err := b.GetPeers(ctx, "leave0")
+	require.NoError(t, err)
+	AssertPeersEqual(t, []backend.Peer{NewPeer(2)}, peers)
+
+	// the same peer in another interface is untouched
+	peers, err = b.GetPeers(ctx, "leave00")
+	require.NoError(t, err)
+	AssertPeersEqual(t, []backend.Peer{NewPeer(1)}, peers)
+}
+
+func testLeaveMissingPeer(t *testing.T, b backend.Backend) {
+	assert.NoError(t, b.Leave(context.Background(), "leavemissing0", NewPeer(1).PublicKey))
+}
+
+func testCancelledContext(t *testing.T, b backend.Backend) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Error(t, b.Join(ctx, "cancelled0", NewPeer(1)))
+	_, err := b.GetPeers(ctx, "cancelled0")
+	assert.Error(t, err)
+	assert.Error(t, b.Leave(ctx, "cancelled0", NewPeer(1).PublicKey))
+}
+
+// testSettings only runs for the backends that are a backend.SettingsStore
+func testSettings(t *testing.T, b backend.Backend) {
+	store, ok := b.(backend.SettingsStore)
+	if !ok {
+		t.Skip("the backend does not store settings")
+	}
+	ctx := context.Background()
+
+	s, err := store.GetSettings(ctx, "settings0")
+	require.NoError(t, err)
+	assert.Nil(t, s, "a network without settings must return nil")
+
+	expected := backend.Settings{EndpointPort: 51820, MTU: 1420, AllowedIPs: []string{"10.1.0.0/16"}, PeerDiscoveryTTL: "10s"}
+	require.NoError(t, b.Join(ctx, "settings0", NewPeer(1)))
+	require.NoError(t, store.PutSettings(ctx, "settings0", expected))
+
+	s, err = store.GetSettings(ctx, "settings0")
+	require.NoError(t, err)
+	require.NotNil(t, s)
+	assert.Equal(t, expected, *s)
+
+	peers, err := b.GetPeers(ctx, "settings0")
+	require.NoError(t, err)
+	AssertPeersEqual(t, []backend.Peer{NewPeer(1)}, peers)
+
+	// the settings of another interface are untouched
+	s, err = store.GetSettings(ctx, "settings00")
+	require.NoError(t, err)
+	assert.Nil(t, s)
+}
+
+// AssertPeersEqual checks that actual contains the expected peers, in any order
+func AssertPeersEqual(t *testing.T, expected, actual []backend.Peer) {
+	t.Helper()
+	require.Len(t, actual, len(expected))
+
+	sortPeers(expected)
+	sortPeers(actual)
+	for i := range expected {
+		AssertPeerEqual(t, expected[i], actual[i])
+	}
+}
+
+// AssertPeerEqual checks that a peer survived a round trip through a backend
+func AssertPeerEqual(t *testing.T, expected, actual backend.Peer) {
+	t.Helper()
+	assert.Equal(t, string(expected.PublicKey), string(actual.PublicKey))
+	assert.Equal(t, expected.Endpoint, actual.Endpoint)
+	assert.Equal(t, expected.AllowedIPs, actual.AllowedIPs)
+	assert.Equal(t, expected.Name, actual.Name)
+	assert.Equal(t, expected.Static, actual.Static)
+	if assert.NotNil(t, actual.IP) {
+		assert.True(t, expected.IP.Equal(*actual.IP), "expected ip %s, got %s", expected.IP, actual.IP)
+	}
+}
+
+func sortPeers(peers []backend.Peer) {
+	sort.Slice(peers, func(i, j int) bool {
+		return string(peers[i].PublicKey) < string(peers[j].PublicKey)
+	})
+}
@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	errCacheNetwork   = "the cached peers are the ones of the network %q, not %q"
+	errCacheNoNetwork = "the cached peers have no network, they were written by an earlier version"
+)
+
+// PeerCache persists the last peer list applied to the interface so that
+// it can be brought up again while the backend is not reachable. The cache
+// is kept with the name of its network, the peers of another network are
+// never restored.
+type PeerCache struct {
+	Path    string
+	Network string
+}
+
+// cacheFile is the content of the cache
+type cacheFile struct {
+	Network string `json:"network"`
+	Peers   []Peer `json:"peers"`
+}
+
+// NewPeerCache ...
+func NewPeerCache(path, network string) *PeerCache {
+	return &PeerCache{
+		Path:    path,
+		Network: network,
+	}
+}
+
+// Load returns the cached peers, or no peers if nothing was cached yet. It
+// fails when they are the peers of another network.
+func (c *PeerCache) Load() ([]Peer, error) {
+	content, err := ioutil.ReadFile(c.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the earlier versions stored the bare list of peers
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("[")) {
+		return nil, fmt.Errorf(errCacheNoNetwork)
+	}
+	cached := cacheFile{}
+	if err := json.Unmarshal(content, &cached); err != nil {
+		return nil, err
+	}
+	if cached.Network != c.Network {
+		return nil, fmt.Errorf(errCacheNetwork, cached.Network, c.Network)
+	}
+	return cached.Peers, nil
+}
+
+// Store replaces the cached peers, the file is swapped atomically so that
+// a crash never leaves a truncated cache behind.
+func (c *PeerCache) Store(peers []Peer) error {
+	content, err := json.Marshal(cacheFile{Network: c.Network, Peers: peers})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.Path), filepath.Base(c.Path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.Path)
+}
@@ -0,0 +1,183 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// Commands accepted by a connected Interface
+const (
+	CommandResync = "resync"
+	CommandRejoin = "rejoin"
+	CommandLeave  = "leave"
+	CommandReload = "reload"
+)
+
+const (
+	errUnknownCommand     = "unknown command %q"
+	errReloadNotSupported = "reload is not supported by this interface"
+)
+
+// command is executed by the Connect loop, between two reconciles, so that
+// it never races with them. done receives the result of the sync that
+// follows the command.
+type command struct {
+	name string
+	done chan error
+}
+
+// Status is a snapshot of an Interface
+type Status struct {
+	State     State
+	LastError error
+	// Joined is false until the local peer is in the backend, and after Leave
+	Joined bool
+	// LastSync is the last time the peers were fetched from the backend
+	LastSync time.Time
+	// BackendError is the error of the last call to the backend, nil when it answered
+	BackendError error
+	// BackendErrorSince is when the backend started failing, zero when it answers
+	BackendErrorSince time.Time
+	// Applied are the peers configured on the device
+	Applied []Peer
+	// LinkUp tells if the link was brought up by the last configuration
+	LinkUp bool
+	// LastActivity is the last time the loop ran, NextActivity when it is
+	// expected to run again after it
+	LastActivity time.Time
+	NextActivity time.Duration
+}
+
+// Status returns a snapshot of the interface
+func (i *Interface) Status() Status {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return Status{
+		State:             i.state,
+		LastError:         i.lastError,
+		Joined:            i.joined,
+		LastSync:          i.lastSync,
+		BackendError:      i.backendErr,
+		BackendErrorSince: i.backendErrSince,
+		Applied:           append([]Peer{}, i.applied...),
+		LinkUp:            i.linkUp,
+		LastActivity:      i.lastActivity,
+		NextActivity:      i.nextActivity,
+	}
+}
+
+// Resync fetches the peers and applies them to the device even if they did
+// not change
+func (i *Interface) Resync(ctx context.Context) error {
+	return i.send(ctx, CommandResync)
+}
+
+// Rejoin registers the local peer in the backend again, then resyncs
+func (i *Interface) Rejoin(ctx context.Context) error {
+	return i.send(ctx, CommandRejoin)
+}
+
+// Leave removes the local peer from the backend, the device keeps being
+// synced with the other peers until Rejoin
+func (i *Interface) Leave(ctx context.Context) error {
+	return i.send(ctx, CommandLeave)
+}
+
+// Reload calls OnReload and joins the backend again, since the local peer
+// may have changed, unless the node left it
+func (i *Interface) Reload(ctx context.Context) error {
+	return i.send(ctx, CommandReload)
+}
+
+// Command sends one of the Command* commands to the Connect loop and waits
+// for its result. It blocks until Connect is running.
+func (i *Interface) Command(ctx context.Context, name string) error {
+	switch name {
+	case CommandResync, CommandRejoin, CommandLeave, CommandReload:
+		return i.send(ctx, name)
+	}
+	return fmt.Errorf(errUnknownCommand, name)
+}
+
+func (i *Interface) send(ctx context.Context, name string) error {
+	c := command{name: name, done: make(chan error, 1)}
+	select {
+	case i.commands() <- c:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-c.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (i *Interface) commands() chan command {
+	i.commandsOnce.Do(func() {
+		i.commandsCh = make(chan command)
+	})
+	return i.commandsCh
+}
+
+// run executes a command inside the Connect loop
+func (i *Interface) run(ctx context.Context, name string) error {
+	switch name {
+	case CommandResync:
+		return nil
+	case CommandRejoin:
+		if err := i.join(ctx, MaxRetries, MaxElapsedTime); err != nil {
+			return err
+		}
+		i.mu.Lock()
+		i.left = false
+		i.mu.Unlock()
+		return nil
+	case CommandLeave:
+		err := backoff.RetryNotify(func() error {
+			err := i.Backend.Leave(ctx, i.network(), i.LocalPeer.PublicKey)
+			i.setBackendError(err)
+			return err
+		}, newBackOff(ctx, MaxRetries, MaxElapsedTime), i.notifyRetry)
+		if err != nil {
+			return err
+		}
+		i.mu.Lock()
+		i.joined = false
+		i.left = true
+		i.mu.Unlock()
+		return nil
+	case CommandReload:
+		if i.OnReload == nil {
+			return fmt.Errorf(errReloadNotSupported)
+		}
+		if err := i.OnReload(i); err != nil {
+			return err
+		}
+		i.mu.RLock()
+		left := i.left
+		i.mu.RUnlock()
+		if left {
+			log.Infoln("The node left the backend, it is not joined again until rejoin")
+			return nil
+		}
+		return i.join(ctx, MaxRetries, MaxElapsedTime)
+	}
+	return fmt.Errorf(errUnknownCommand, name)
+}
+
+func (i *Interface) setBackendError(err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err == nil {
+		i.backendErrSince = time.Time{}
+	} else if i.backendErrSince.IsZero() {
+		i.backendErrSince = time.Now()
+	}
+	i.backendErr = err
+}
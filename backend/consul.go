@@ -1,26 +1,32 @@
 package backend
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-
-	"wirey/pkg/utils"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	log "github.com/sirupsen/logrus"
 )
 
-const (
-	consulWireyPrefix = "wirey"
-)
-
 // ConsulBackend ...
 type ConsulBackend struct {
-	client *api.Client
+	client  *api.Client
+	timeout time.Duration
+	// Prefix is the root of every key, DefaultKeyPrefix unless changed
+	Prefix string
+	// LegacyPrefix is the root of the records written before the key
+	// schema, which MigrateKeys moves under Prefix. DefaultKeyPrefix unless
+	// changed, the earlier versions had no other.
+	LegacyPrefix string
 }
 
-// NewConsulBackend ...
-func NewConsulBackend(endpoint string, token string) (*ConsulBackend, error) {
+// NewConsulBackend creates a consul backend, timeout bounds every call made
+// to the agent. The agent is not contacted, a daemon starting while it is
+// down runs from its cached peers until it is reachable.
+func NewConsulBackend(endpoint string, token string, timeout time.Duration) (*ConsulBackend, error) {
 
 	config := api.DefaultConfig()
 	config.Address = endpoint
@@ -36,35 +42,34 @@ func NewConsulBackend(endpoint string, token string) (*ConsulBackend, error) {
 		return nil, err
 	}
 
-	// check health to ensure communication with consul are working
-	if _, _, err := cli.Health().State(api.HealthAny, nil); err != nil {
-		log.Errorf("consul: health check failed for %v : %v", config.Address, err)
-		return nil, err
-	}
-
 	return &ConsulBackend{
-		client: cli,
+		client:       cli,
+		timeout:      timeout,
+		Prefix:       DefaultKeyPrefix,
+		LegacyPrefix: DefaultKeyPrefix,
 	}, nil
 }
 
 // Join ...
-func (e *ConsulBackend) Join(ifname string, p Peer) error {
+func (e *ConsulBackend) Join(ctx context.Context, ifname string, p Peer) error {
 	pj, err := json.Marshal(p)
 
 	if err != nil {
 		return err
 	}
 
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
 	kvc := e.client.KV()
 
-	log.Debugf("consul: inserting key on %s/%s/%s\n", consulWireyPrefix, ifname, utils.PublicKeySHA256(p.PublicKey))
+	log.Debugf("consul: inserting key on %s\n", e.key(PeerPath(ifname, p.PublicKey)))
 
 	_, err = kvc.Put(
 		&api.KVPair{
-			Key:   fmt.Sprintf("%s/%s/%s", consulWireyPrefix, ifname, utils.PublicKeySHA256(p.PublicKey)),
+			Key:   e.key(PeerPath(ifname, p.PublicKey)),
 			Value: pj,
 		},
-		nil,
+		(&api.WriteOptions{}).WithContext(ctx),
 	)
 
 	if err != nil {
@@ -73,10 +78,27 @@ func (e *ConsulBackend) Join(ifname string, p Peer) error {
 	return nil
 }
 
+// Leave ...
+func (e *ConsulBackend) Leave(ctx context.Context, ifname string, publicKey []byte) error {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+	kvc := e.client.KV()
+
+	log.Debugf("consul: deleting key %s\n", e.key(PeerPath(ifname, publicKey)))
+
+	_, err := kvc.Delete(
+		e.key(PeerPath(ifname, publicKey)),
+		(&api.WriteOptions{}).WithContext(ctx),
+	)
+	return err
+}
+
 // GetPeers ...
-func (e *ConsulBackend) GetPeers(ifname string) ([]Peer, error) {
+func (e *ConsulBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
 	kvc := e.client.KV()
-	res, _, err := kvc.List(fmt.Sprintf("%s/%s", consulWireyPrefix, ifname), nil)
+	res, _, err := kvc.List(e.key(PeersPath(ifname))+"/", (&api.QueryOptions{}).WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -103,3 +125,130 @@ func (e *ConsulBackend) GetPeers(ifname string) ([]Peer, error) {
 
 	return peers, nil
 }
+
+// key returns the consul key of a path of the key schema
+func (e *ConsulBackend) key(path string) string {
+	return prefixed(e.Prefix, path)
+}
+
+// legacyKey returns the consul key of a path under the LegacyPrefix
+func (e *ConsulBackend) legacyKey(path string) string {
+	return prefixed(e.LegacyPrefix, path)
+}
+
+// isSchemaKey tells whether key is a record of the key schema, under the
+// Prefix or the LegacyPrefix
+func (e *ConsulBackend) isSchemaKey(key string) bool {
+	for _, root := range []string{e.key(""), e.legacyKey("")} {
+		if strings.HasPrefix(key, root) && isSchemaPath(strings.TrimPrefix(key, root)) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSettings ...
+func (e *ConsulBackend) GetSettings(ctx context.Context, ifname string) (*Settings, error) {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+	kvc := e.client.KV()
+	pair, _, err := kvc.Get(e.key(SettingsPath(ifname)), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, nil
+	}
+
+	s := &Settings{}
+	if err := json.Unmarshal(pair.Value, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// PutSettings ...
+func (e *ConsulBackend) PutSettings(ctx context.Context, ifname string, s Settings) error {
+	sj, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+	kvc := e.client.KV()
+
+	log.Debugf("consul: inserting key on %s\n", e.key(SettingsPath(ifname)))
+
+	_, err = kvc.Put(
+		&api.KVPair{
+			Key:   e.key(SettingsPath(ifname)),
+			Value: sj,
+		},
+		(&api.WriteOptions{}).WithContext(ctx),
+	)
+	return err
+}
+
+// MigrateKeys moves the records stored under {legacy prefix}/{network}/{sha}
+// and {legacy prefix}/{network}/_config to the key schema under the Prefix
+func (e *ConsulBackend) MigrateKeys(ctx context.Context, network string, opts MigrateOptions) ([]string, error) {
+	legacyPrefix := e.legacyKey(network + "/")
+	kvc := e.client.KV()
+
+	callCtx, cancel := withTimeout(ctx, e.timeout)
+	res, _, err := kvc.List(legacyPrefix, (&api.QueryOptions{}).WithContext(callCtx))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, v := range res {
+		if e.isSchemaKey(v.Key) {
+			continue
+		}
+
+		target := e.key(SettingsPath(network))
+		if v.Key != legacyPrefix+settingsName {
+			peer := Peer{}
+			if err := json.Unmarshal(v.Value, &peer); err != nil {
+				return keys, fmt.Errorf("unable to decode %q: %s", v.Key, err.Error())
+			}
+			target = e.key(PeerPath(network, peer.PublicKey))
+		}
+		keys = append(keys, v.Key)
+		if opts.DryRun {
+			continue
+		}
+		if err := e.move(ctx, v, target, opts.Keep); err != nil {
+			return keys, err
+		}
+	}
+	return keys, nil
+}
+
+// move writes the value of pair to target, unless it is already there, then
+// deletes pair unless keep is set
+func (e *ConsulBackend) move(ctx context.Context, pair *api.KVPair, target string, keep bool) error {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	defer cancel()
+	kvc := e.client.KV()
+
+	log.Debugf("consul: moving key %s to %s\n", pair.Key, target)
+
+	existing, _, err := kvc.Get(target, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		if _, err := kvc.Put(&api.KVPair{Key: target, Value: pair.Value}, (&api.WriteOptions{}).WithContext(ctx)); err != nil {
+			return err
+		}
+	}
+	if keep {
+		return nil
+	}
+	_, err = kvc.Delete(pair.Key, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
@@ -0,0 +1,218 @@
+package backend_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"wirey/backend"
+	"wirey/backend/backendtest"
+	"wirey/pkg/utils"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConsul implements the subset of the consul KV http api used by the backend
+type fakeConsul struct {
+	mutex sync.Mutex
+	kv    map[string][]byte
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if r.URL.Path == "/v1/health/state/any" {
+		w.Write([]byte("[]"))
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/v1/kv/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	_, recurse := r.URL.Query()["recurse"]
+
+	switch r.Method {
+	case http.MethodPut:
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.kv[key] = value
+		w.Write([]byte("true"))
+	case http.MethodGet:
+		pairs := api.KVPairs{}
+		for k, v := range f.kv {
+			if k == key || (recurse && strings.HasPrefix(k, key)) {
+				pairs = append(pairs, &api.KVPair{Key: k, Value: v})
+			}
+		}
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+		json.NewEncoder(w).Encode(pairs)
+	case http.MethodDelete:
+		delete(f.kv, key)
+		w.Write([]byte("true"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestConsulBackend(t *testing.T) {
+	server := httptest.NewServer(&fakeConsul{kv: map[string][]byte{}})
+	defer server.Close()
+
+	b, err := backend.NewConsulBackend(strings.TrimPrefix(server.URL, "http://"), "", 5*time.Second)
+	require.NoError(t, err)
+
+	backendtest.Run(t, b)
+}
+
+func TestConsulBackendMigrateKeys(t *testing.T) {
+	ip := net.IPv4(10, 0, 0, 2)
+	peer := backend.Peer{PublicKey: []byte("peer-2-public-key\n"), Endpoint: "192.168.0.2:2345", IP: &ip}
+	pj, err := json.Marshal(peer)
+	require.NoError(t, err)
+
+	legacy := "wirey/net/" + utils.PublicKeySHA256(peer.PublicKey)
+	fake := &fakeConsul{kv: map[string][]byte{
+		legacy:              pj,
+		"wirey/net/_config": []byte(`{"mtu":1420}`),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	b, err := backend.NewConsulBackend(strings.TrimPrefix(server.URL, "http://"), "", 5*time.Second)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	keys, err := b.MigrateKeys(ctx, "net", backend.MigrateOptions{DryRun: true})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{legacy, "wirey/net/_config"}, keys)
+	require.Contains(t, fake.kv, legacy, "a dry run changes nothing")
+
+	_, err = b.MigrateKeys(ctx, "net", backend.MigrateOptions{Keep: true})
+	require.NoError(t, err)
+	require.Contains(t, fake.kv, legacy, "the legacy records are kept")
+	require.Contains(t, fake.kv, "wirey/"+backend.PeerPath("net", peer.PublicKey))
+
+	_, err = b.MigrateKeys(ctx, "net", backend.MigrateOptions{})
+	require.NoError(t, err)
+	require.NotContains(t, fake.kv, legacy)
+
+	peers, err := b.GetPeers(ctx, "net")
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	require.Equal(t, peer.PublicKey, peers[0].PublicKey)
+
+	s, err := b.GetSettings(ctx, "net")
+	require.NoError(t, err)
+	require.Equal(t, &backend.Settings{MTU: 1420}, s)
+
+	keys, err = b.MigrateKeys(ctx, "net", backend.MigrateOptions{})
+	require.NoError(t, err)
+	require.Empty(t, keys, "the records are in the key schema")
+}
+
+func TestConsulBackendMigrateKeysOfNetworkV1(t *testing.T) {
+	ip := net.IPv4(10, 0, 0, 2)
+	peer := backend.Peer{PublicKey: []byte("peer-2-public-key\n"), Endpoint: "192.168.0.2:2345", IP: &ip}
+	pj, err := json.Marshal(peer)
+	require.NoError(t, err)
+
+	// the legacy records of v1 share their root with the schema of every network
+	legacy := "wirey/v1/" + utils.PublicKeySHA256(peer.PublicKey)
+	other := "wirey/" + backend.PeerPath("net", peer.PublicKey)
+	fake := &fakeConsul{kv: map[string][]byte{
+		legacy:                 pj,
+		other:                  pj,
+		"wirey/v1/_config":     []byte(`{"mtu":1420}`),
+		"wirey/v1/net/_config": []byte(`{"mtu":1380}`),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	b, err := backend.NewConsulBackend(strings.TrimPrefix(server.URL, "http://"), "", 5*time.Second)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	keys, err := b.MigrateKeys(ctx, "v1", backend.MigrateOptions{})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{legacy, "wirey/v1/_config"}, keys)
+	require.Contains(t, fake.kv, other, "the records of other networks are left alone")
+
+	peers, err := b.GetPeers(ctx, "v1")
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+
+	s, err := b.GetSettings(ctx, "v1")
+	require.NoError(t, err)
+	require.Equal(t, &backend.Settings{MTU: 1420}, s)
+
+	keys, err = b.MigrateKeys(ctx, "v1", backend.MigrateOptions{})
+	require.NoError(t, err)
+	require.Empty(t, keys, "the records are in the key schema")
+}
+
+func TestConsulBackendMigrateKeysToPrefix(t *testing.T) {
+	ip := net.IPv4(10, 0, 0, 2)
+	peer := backend.Peer{PublicKey: []byte("peer-2-public-key\n"), Endpoint: "192.168.0.2:2345", IP: &ip}
+	pj, err := json.Marshal(peer)
+	require.NoError(t, err)
+
+	// the legacy records always lived under wirey
+	legacy := "wirey/net/" + utils.PublicKeySHA256(peer.PublicKey)
+	fake := &fakeConsul{kv: map[string][]byte{legacy: pj}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	b, err := backend.NewConsulBackend(strings.TrimPrefix(server.URL, "http://"), "", 5*time.Second)
+	require.NoError(t, err)
+	b.Prefix = "team-a"
+	ctx := context.Background()
+
+	keys, err := b.MigrateKeys(ctx, "net", backend.MigrateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{legacy}, keys)
+	require.Equal(t, map[string][]byte{"team-a/" + backend.PeerPath("net", peer.PublicKey): pj}, fake.kv)
+}
+
+func TestConsulBackendPrefix(t *testing.T) {
+	fake := &fakeConsul{kv: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	staging, err := backend.NewConsulBackend(strings.TrimPrefix(server.URL, "http://"), "", 5*time.Second)
+	require.NoError(t, err)
+	staging.Prefix = "/team-a/staging/"
+	prod, err := backend.NewConsulBackend(strings.TrimPrefix(server.URL, "http://"), "", 5*time.Second)
+	require.NoError(t, err)
+
+	ip := net.IPv4(10, 0, 0, 2)
+	peer := backend.Peer{PublicKey: []byte("peer-2-public-key\n"), Endpoint: "192.168.0.2:2345", IP: &ip}
+	ctx := context.Background()
+	require.NoError(t, staging.Join(ctx, "wg0", peer))
+	require.Contains(t, fake.kv, "team-a/staging/v1/wg0/peers/"+utils.PublicKeySHA256(peer.PublicKey))
+
+	peers, err := prod.GetPeers(ctx, "wg0")
+	require.NoError(t, err)
+	require.Empty(t, peers, "the prefixes are isolated")
+	peers, err = staging.GetPeers(ctx, "wg0")
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+}
@@ -0,0 +1,139 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Peer fields, as reported by PeerChange
+const (
+	FieldEndpoint   = "endpoint"
+	FieldIP         = "ip"
+	FieldAllowedIPs = "allowedIPs"
+	FieldName       = "name"
+	FieldStatic     = "static"
+)
+
+// PeerDiff is the difference between two peer sets, keyed by public key
+type PeerDiff struct {
+	Added   []Peer
+	Removed []Peer
+	Changed []PeerChange
+}
+
+// PeerChange is a peer whose record changed
+type PeerChange struct {
+	Old Peer
+	New Peer
+	// Fields are the fields that differ
+	Fields []string
+}
+
+// Empty tells if the peer sets are the same
+func (d PeerDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String summarizes the diff for the logs
+func (d PeerDiff) String() string {
+	return fmt.Sprintf("%d added, %d removed, %d changed", len(d.Added), len(d.Removed), len(d.Changed))
+}
+
+// DiffPeers compares the peer sets, the results are sorted by public key.
+// The slices passed are not modified.
+func DiffPeers(old, new []Peer) PeerDiff {
+	oldPeers := map[string]Peer{}
+	for _, p := range old {
+		oldPeers[string(p.PublicKey)] = p
+	}
+
+	d := PeerDiff{}
+	seen := map[string]bool{}
+	for _, p := range new {
+		key := string(p.PublicKey)
+		seen[key] = true
+		o, ok := oldPeers[key]
+		if !ok {
+			d.Added = append(d.Added, p)
+			continue
+		}
+		if fields := changedFields(o, p); len(fields) > 0 {
+			d.Changed = append(d.Changed, PeerChange{Old: o, New: p, Fields: fields})
+		}
+	}
+	for _, p := range old {
+		if !seen[string(p.PublicKey)] {
+			d.Removed = append(d.Removed, p)
+		}
+	}
+
+	sortPeers(d.Added)
+	sortPeers(d.Removed)
+	sort.Slice(d.Changed, func(i, j int) bool {
+		return bytes.Compare(d.Changed[i].New.PublicKey, d.Changed[j].New.PublicKey) < 0
+	})
+	return d
+}
+
+// changedFields lists the fields that differ between two records of a peer
+func changedFields(old, new Peer) []string {
+	fields := []string{}
+	if old.Endpoint != new.Endpoint {
+		fields = append(fields, FieldEndpoint)
+	}
+	if !sameIP(old.IP, new.IP) {
+		fields = append(fields, FieldIP)
+	}
+	if strings.Join(old.AllowedIPs, ",") != strings.Join(new.AllowedIPs, ",") {
+		fields = append(fields, FieldAllowedIPs)
+	}
+	if old.Name != new.Name {
+		fields = append(fields, FieldName)
+	}
+	if old.Static != new.Static {
+		fields = append(fields, FieldStatic)
+	}
+	return fields
+}
+
+func sameIP(a, b *net.IP) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func sortPeers(peers []Peer) {
+	sort.Slice(peers, func(i, j int) bool {
+		return bytes.Compare(peers[i].PublicKey, peers[j].PublicKey) < 0
+	})
+}
+
+// logDiff logs every peer of the diff
+func logDiff(d PeerDiff) {
+	for _, p := range d.Added {
+		log.Infof("Peer %s added with ip %s", peerKey(p), peerIP(p))
+	}
+	for _, p := range d.Removed {
+		log.Infof("Peer %s removed", peerKey(p))
+	}
+	for _, c := range d.Changed {
+		log.Infof("Peer %s changed: %s", peerKey(c.New), strings.Join(c.Fields, ", "))
+	}
+}
+
+func peerKey(p Peer) string {
+	return strings.TrimSpace(string(p.PublicKey))
+}
+
+func peerIP(p Peer) string {
+	if p.IP == nil {
+		return "-"
+	}
+	return p.IP.String()
+}
@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"wirey/pkg/wireguard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffPeers(t *testing.T) {
+	changed := testPeer(3)
+	changed.Endpoint = "192.168.1.3:2345"
+	ip := net.ParseIP("10.0.1.3")
+	changed.IP = &ip
+	changed.AllowedIPs = []string{"10.1.0.0/16"}
+
+	old := []Peer{testPeer(3), testPeer(2)}
+	d := DiffPeers(old, []Peer{testPeer(4), changed})
+	assert.Equal(t, []Peer{testPeer(4)}, d.Added)
+	assert.Equal(t, []Peer{testPeer(2)}, d.Removed)
+	assert.Equal(t, []PeerChange{{Old: testPeer(3), New: changed, Fields: []string{FieldEndpoint, FieldIP, FieldAllowedIPs}}}, d.Changed)
+	assert.Equal(t, "1 added, 1 removed, 1 changed", d.String())
+	assert.Equal(t, []Peer{testPeer(3), testPeer(2)}, old, "the peers passed are not sorted")
+
+	same := testPeer(2)
+	sameIP := net.ParseIP("10.0.0.2")
+	same.IP = &sameIP
+	assert.True(t, DiffPeers([]Peer{testPeer(2)}, []Peer{same}).Empty())
+	assert.True(t, DiffPeers(nil, []Peer{}).Empty())
+}
+
+// updatingDataplane is a recordingDataplane that updates the peers in place
+type updatingDataplane struct {
+	recordingDataplane
+	fail bool
+}
+
+func (u *updatingDataplane) UpdatePeers(name string, set []wireguard.Peer, remove []string) error {
+	call := "UpdatePeers " + name
+	for _, p := range set {
+		call += " +" + strings.TrimSpace(p.PublicKey)
+	}
+	for _, key := range remove {
+		call += " -" + strings.TrimSpace(key)
+	}
+	u.record(call)
+	if u.fail {
+		return fmt.Errorf("unable to update")
+	}
+	return nil
+}
+
+func TestConnectUpdatesPeersInPlace(t *testing.T) {
+	b := NewMemoryBackend()
+	d := &updatingDataplane{}
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(2)))
+
+	o := &recordingObserver{}
+	i := testInterface(b, &d.recordingDataplane)
+	i.Dataplane = d
+	i.Observers = []Observer{o}
+	stop := connect(t, i)
+	waitForPeers(t, &d.recordingDataplane, testPeer(2))
+
+	require.NoError(t, b.Leave(context.Background(), "wg0", testPeer(2).PublicKey))
+	require.Eventually(t, func() bool { return len(i.Status().Applied) == 0 }, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(3)))
+	require.Eventually(t, func() bool { return len(i.Status().Applied) == 1 }, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, stop())
+
+	assert.Equal(t, []string{
+		"LinkAdd wg0", "SetConf wg0", "AddrAdd wg0 10.0.0.1/24", "LinkUp wg0",
+		"UpdatePeers wg0 -peer-2-public-key",
+		"UpdatePeers wg0 +peer-3-public-key",
+	}, d.Calls(), "the link is created once")
+	assert.Equal(t, []string{
+		EventPostUp, EventPeerAdded, EventPeerRemoved, EventPeerAdded, EventPreDown,
+	}, o.types())
+}
+
+func TestConnectResetsTheEndpointThatWentAway(t *testing.T) {
+	b := NewMemoryBackend()
+	d := &updatingDataplane{}
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(2)))
+
+	i := testInterface(b, &d.recordingDataplane)
+	i.Dataplane = d
+	stop := connect(t, i)
+	waitForPeers(t, &d.recordingDataplane, testPeer(2))
+
+	roaming := testPeer(2)
+	roaming.Endpoint = ""
+	require.NoError(t, b.Join(context.Background(), "wg0", roaming))
+	require.Eventually(t, func() bool {
+		applied := i.Status().Applied
+		return len(applied) == 1 && applied[0].Endpoint == ""
+	}, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, stop())
+
+	assert.Equal(t, []string{
+		"LinkAdd wg0", "SetConf wg0", "AddrAdd wg0 10.0.0.1/24", "LinkUp wg0",
+		"UpdatePeers wg0 -peer-2-public-key",
+		"UpdatePeers wg0 +peer-2-public-key",
+	}, d.Calls(), "the peer is removed before it is set without endpoint")
+}
+
+func TestConnectRecreatesTheLinkWhenTheUpdateFails(t *testing.T) {
+	b := NewMemoryBackend()
+	d := &updatingDataplane{fail: true}
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(2)))
+
+	i := testInterface(b, &d.recordingDataplane)
+	i.Dataplane = d
+	stop := connect(t, i)
+	waitForPeers(t, &d.recordingDataplane, testPeer(2))
+
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(3)))
+	waitForPeers(t, &d.recordingDataplane, testPeer(2), testPeer(3))
+	require.NoError(t, stop())
+}
@@ -4,22 +4,26 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
-	"go.etcd.io/etcd/clientv3"
-)
-
-const (
-	etcdWireyPrefix = "/wirey"
+	"github.com/coreos/etcd/clientv3"
 )
 
 // EtcdBackend ...
 type EtcdBackend struct {
-	client *clientv3.Client
+	client  *clientv3.Client
+	timeout time.Duration
+	// Prefix is the root of every key, DefaultKeyPrefix unless changed
+	Prefix string
+	// LegacyPrefix is the root of the records written before the key
+	// schema, which MigrateKeys moves under Prefix. DefaultKeyPrefix unless
+	// changed, the earlier versions had no other.
+	LegacyPrefix string
 }
 
-// NewEtcdBackend ...
-func NewEtcdBackend(endpoints []string) (*EtcdBackend, error) {
+// NewEtcdBackend creates an etcd backend, timeout bounds every call made to the cluster
+func NewEtcdBackend(endpoints []string, timeout time.Duration) (*EtcdBackend, error) {
 	cli, err := clientv3.New(clientv3.Config{
 		Endpoints:   endpoints,
 		DialTimeout: 5 * time.Second,
@@ -28,20 +32,44 @@ func NewEtcdBackend(endpoints []string) (*EtcdBackend, error) {
 		return nil, err
 	}
 	return &EtcdBackend{
-		client: cli,
+		client:       cli,
+		timeout:      timeout,
+		Prefix:       DefaultKeyPrefix,
+		LegacyPrefix: DefaultKeyPrefix,
 	}, nil
 }
 
+// key returns the etcd key of a path of the key schema
+func (e *EtcdBackend) key(path string) string {
+	return "/" + prefixed(e.Prefix, path)
+}
+
+// legacyKey returns the etcd key of a path under the LegacyPrefix
+func (e *EtcdBackend) legacyKey(path string) string {
+	return "/" + prefixed(e.LegacyPrefix, path)
+}
+
+// isSchemaKey tells whether key is a record of the key schema, under the
+// Prefix or the LegacyPrefix
+func (e *EtcdBackend) isSchemaKey(key string) bool {
+	for _, root := range []string{e.key(""), e.legacyKey("")} {
+		if strings.HasPrefix(key, root) && isSchemaPath(strings.TrimPrefix(key, root)) {
+			return true
+		}
+	}
+	return false
+}
+
 // Join ...
-func (e *EtcdBackend) Join(ifname string, p Peer) error {
+func (e *EtcdBackend) Join(ctx context.Context, ifname string, p Peer) error {
 	pj, err := json.Marshal(p)
 
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	ctx, cancel := withTimeout(ctx, e.timeout)
 	kvc := clientv3.NewKV(e.client)
-	_, err = kvc.Put(ctx, fmt.Sprintf("%s/%s/%s", etcdWireyPrefix, ifname, p.PublicKey), string(pj))
+	_, err = kvc.Put(ctx, e.key(PeerPath(ifname, p.PublicKey)), string(pj))
 	cancel()
 	if err != nil {
 		return err
@@ -49,11 +77,20 @@ func (e *EtcdBackend) Join(ifname string, p Peer) error {
 	return nil
 }
 
+// Leave ...
+func (e *EtcdBackend) Leave(ctx context.Context, ifname string, publicKey []byte) error {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	kvc := clientv3.NewKV(e.client)
+	_, err := kvc.Delete(ctx, e.key(PeerPath(ifname, publicKey)))
+	cancel()
+	return err
+}
+
 // GetPeers ...
-func (e *EtcdBackend) GetPeers(ifname string) ([]Peer, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+func (e *EtcdBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	ctx, cancel := withTimeout(ctx, e.timeout)
 	kvc := clientv3.NewKV(e.client)
-	res, err := kvc.Get(ctx, fmt.Sprintf("%s/%s", etcdWireyPrefix, ifname), clientv3.WithPrefix())
+	res, err := kvc.Get(ctx, e.key(PeersPath(ifname))+"/", clientv3.WithPrefix())
 	cancel()
 	if err != nil {
 		return nil, err
@@ -70,3 +107,90 @@ func (e *EtcdBackend) GetPeers(ifname string) ([]Peer, error) {
 	}
 	return peers, nil
 }
+
+// GetSettings ...
+func (e *EtcdBackend) GetSettings(ctx context.Context, ifname string) (*Settings, error) {
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	kvc := clientv3.NewKV(e.client)
+	res, err := kvc.Get(ctx, e.key(SettingsPath(ifname)))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Kvs) == 0 {
+		return nil, nil
+	}
+
+	s := &Settings{}
+	if err := json.Unmarshal(res.Kvs[0].Value, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// PutSettings ...
+func (e *EtcdBackend) PutSettings(ctx context.Context, ifname string, s Settings) error {
+	sj, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, e.timeout)
+	kvc := clientv3.NewKV(e.client)
+	_, err = kvc.Put(ctx, e.key(SettingsPath(ifname)), string(sj))
+	cancel()
+	return err
+}
+
+// MigrateKeys moves the records stored under
+// /{legacy prefix}/{network}/{public key} and /{legacy prefix}/{network}/_config
+// to the key schema under the Prefix, every record is moved in a transaction
+func (e *EtcdBackend) MigrateKeys(ctx context.Context, network string, opts MigrateOptions) ([]string, error) {
+	legacyPrefix := e.legacyKey(network + "/")
+	kvc := clientv3.NewKV(e.client)
+
+	callCtx, cancel := withTimeout(ctx, e.timeout)
+	res, err := kvc.Get(callCtx, legacyPrefix, clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, v := range res.Kvs {
+		key := string(v.Key)
+		if e.isSchemaKey(key) {
+			continue
+		}
+
+		target := e.key(SettingsPath(network))
+		if key != legacyPrefix+settingsName {
+			peer := Peer{}
+			if err := json.Unmarshal(v.Value, &peer); err != nil {
+				return keys, fmt.Errorf("unable to decode %q: %s", key, err.Error())
+			}
+			target = e.key(PeerPath(network, peer.PublicKey))
+		}
+		keys = append(keys, key)
+		if opts.DryRun {
+			continue
+		}
+
+		put := []clientv3.Op{clientv3.OpPut(target, string(v.Value))}
+		drop := []clientv3.Op{}
+		if !opts.Keep {
+			put = append(put, clientv3.OpDelete(key))
+			drop = append(drop, clientv3.OpDelete(key))
+		}
+		callCtx, cancel := withTimeout(ctx, e.timeout)
+		_, err := kvc.Txn(callCtx).
+			If(clientv3.Compare(clientv3.CreateRevision(target), "=", 0)).
+			Then(put...).
+			Else(drop...).
+			Commit()
+		cancel()
+		if err != nil {
+			return keys, err
+		}
+	}
+	return keys, nil
+}
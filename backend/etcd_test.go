@@ -0,0 +1,107 @@
+//go:build integration
+// +build integration
+
+// The embedded etcd runs on github.com/coreos/bbolt, whose unsafe pointer
+// conversions fail the checkptr instrumentation of the race detector: the fix
+// is only in go.etcd.io/bbolt, which etcd v3.3 does not import. The suite runs
+// in make integration-etcd, without -race.
+
+package backend_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"wirey/backend"
+	"wirey/backend/backendtest"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/embed"
+	"github.com/coreos/pkg/capnslog"
+	"github.com/stretchr/testify/require"
+)
+
+// startEtcd runs an embedded etcd until the returned function is called
+func startEtcd(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "wirey-etcd")
+	require.NoError(t, err)
+
+	capnslog.SetGlobalLogLevel(capnslog.CRITICAL)
+
+	clientURL, _ := url.Parse("http://127.0.0.1:0")
+	peerURL, _ := url.Parse("http://127.0.0.1:0")
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LCUrls = []url.URL{*clientURL}
+	cfg.LPUrls = []url.URL{*peerURL}
+
+	etcd, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	select {
+	case <-etcd.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		etcd.Close()
+		os.RemoveAll(dir)
+		t.Fatal("embedded etcd did not become ready")
+	}
+	return etcd.Clients[0].Addr().String(), func() {
+		etcd.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEtcdBackend(t *testing.T) {
+	endpoint, stop := startEtcd(t)
+	defer stop()
+
+	b, err := backend.NewEtcdBackend([]string{endpoint}, 5*time.Second)
+	require.NoError(t, err)
+
+	backendtest.Run(t, b)
+}
+
+func TestEtcdBackendMigrateKeys(t *testing.T) {
+	endpoint, stop := startEtcd(t)
+	defer stop()
+
+	ip := net.IPv4(10, 0, 0, 2)
+	peer := backend.Peer{PublicKey: []byte("cGVlci0yLXB1YmxpYy1rZXk=\n"), Endpoint: "192.168.0.2:2345", IP: &ip}
+	pj, err := json.Marshal(peer)
+	require.NoError(t, err)
+
+	// the legacy layout used the raw public key, with its newline
+	legacy := "/wirey/net/" + string(peer.PublicKey)
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, DialTimeout: 5 * time.Second})
+	require.NoError(t, err)
+	defer client.Close()
+	ctx := context.Background()
+	_, err = client.Put(ctx, legacy, string(pj))
+	require.NoError(t, err)
+
+	b, err := backend.NewEtcdBackend([]string{endpoint}, 5*time.Second)
+	require.NoError(t, err)
+
+	keys, err := b.MigrateKeys(ctx, "net", backend.MigrateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{legacy}, keys)
+
+	res, err := client.Get(ctx, legacy)
+	require.NoError(t, err)
+	require.Empty(t, res.Kvs)
+
+	peers, err := b.GetPeers(ctx, "net")
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	require.Equal(t, peer.PublicKey, peers[0].PublicKey)
+}
@@ -0,0 +1,160 @@
+package backend
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Event types
+const (
+	// EventPostUp is sent after the link is brought up with its peers
+	EventPostUp = "PostUp"
+	// EventPreDown is sent before the link is recreated and when the loop stops
+	EventPreDown = "PreDown"
+	// EventPeerAdded is sent for every peer that was not applied before
+	EventPeerAdded = "PeerAdded"
+	// EventPeerRemoved is sent for every peer that is not applied anymore
+	EventPeerRemoved = "PeerRemoved"
+	// EventPeerChanged is sent for every applied peer whose record changed
+	EventPeerChanged = "PeerChanged"
+)
+
+// Event describes a change of the link or of its peers
+type Event struct {
+	Type      string
+	Interface string
+	// Network is the name of the network in the backend, the same on every
+	// node while Interface is the local device
+	Network string
+	Time    time.Time
+	// Peer is the peer of the PeerAdded, PeerRemoved and PeerChanged
+	// events, the new record for PeerChanged
+	Peer *Peer
+	// Previous is the old record of the peer for PeerChanged
+	Previous *Peer
+	// Peers are the peers applied when the event is sent
+	Peers []Peer
+	// Diff is the whole change the event belongs to
+	Diff PeerDiff
+	// Initial is set on the events of the first apply since Connect
+	// started, its peers were not necessarily added just now
+	Initial bool
+}
+
+// Observer receives the events of an Interface. It is called from the
+// Connect loop, which waits for it to return.
+type Observer interface {
+	OnEvent(e Event)
+}
+
+// peerEvents returns the PeerAdded, PeerRemoved and PeerChanged events of d
+func peerEvents(d PeerDiff, peers []Peer, initial bool) []Event {
+	events := []Event{}
+	for n := range d.Added {
+		events = append(events, Event{Type: EventPeerAdded, Peer: &d.Added[n], Peers: peers, Diff: d, Initial: initial})
+	}
+	for n := range d.Removed {
+		events = append(events, Event{Type: EventPeerRemoved, Peer: &d.Removed[n], Peers: peers, Diff: d, Initial: initial})
+	}
+	for n := range d.Changed {
+		events = append(events, Event{Type: EventPeerChanged, Peer: &d.Changed[n].New, Previous: &d.Changed[n].Old, Peers: peers, Diff: d, Initial: initial})
+	}
+	return events
+}
+
+// notify delivers e to the observers
+func (i *Interface) notify(e Event) {
+	e.Interface = i.Name
+	e.Network = i.network()
+	e.Time = time.Now()
+	for _, o := range i.Observers {
+		o.OnEvent(e)
+	}
+}
+
+// eventPeer is how a peer is encoded in the JSON of an event
+type eventPeer struct {
+	PublicKey  string   `json:"publicKey"`
+	Name       string   `json:"name,omitempty"`
+	IP         string   `json:"ip,omitempty"`
+	Endpoint   string   `json:"endpoint,omitempty"`
+	AllowedIPs []string `json:"allowedIPs,omitempty"`
+	Static     bool     `json:"static,omitempty"`
+}
+
+type eventChange struct {
+	Old    eventPeer `json:"old"`
+	New    eventPeer `json:"new"`
+	Fields []string  `json:"fields"`
+}
+
+type eventDiff struct {
+	Added   []eventPeer   `json:"added"`
+	Removed []eventPeer   `json:"removed"`
+	Changed []eventChange `json:"changed"`
+}
+
+type eventJSON struct {
+	Type      string      `json:"type"`
+	Interface string      `json:"interface"`
+	Network   string      `json:"network"`
+	Time      time.Time   `json:"time"`
+	Peer      *eventPeer  `json:"peer,omitempty"`
+	Previous  *eventPeer  `json:"previous,omitempty"`
+	Peers     []eventPeer `json:"peers"`
+	Diff      eventDiff   `json:"diff"`
+	Initial   bool        `json:"initial,omitempty"`
+}
+
+// MarshalJSON encodes the event with readable public keys and ips, this is
+// what the hooks receive
+func (e Event) MarshalJSON() ([]byte, error) {
+	j := eventJSON{
+		Type:      e.Type,
+		Interface: e.Interface,
+		Network:   e.Network,
+		Time:      e.Time,
+		Initial:   e.Initial,
+		Peers:     newEventPeers(e.Peers),
+		Diff: eventDiff{
+			Added:   newEventPeers(e.Diff.Added),
+			Removed: newEventPeers(e.Diff.Removed),
+			Changed: []eventChange{},
+		},
+	}
+	if e.Peer != nil {
+		p := newEventPeer(*e.Peer)
+		j.Peer = &p
+	}
+	if e.Previous != nil {
+		p := newEventPeer(*e.Previous)
+		j.Previous = &p
+	}
+	for _, c := range e.Diff.Changed {
+		j.Diff.Changed = append(j.Diff.Changed, eventChange{Old: newEventPeer(c.Old), New: newEventPeer(c.New), Fields: c.Fields})
+	}
+	return json.Marshal(j)
+}
+
+func newEventPeer(p Peer) eventPeer {
+	j := eventPeer{
+		PublicKey:  strings.TrimSpace(string(p.PublicKey)),
+		Name:       p.Name,
+		Endpoint:   p.Endpoint,
+		AllowedIPs: p.AllowedIPs,
+		Static:     p.Static,
+	}
+	if p.IP != nil {
+		j.IP = p.IP.String()
+	}
+	return j
+}
+
+func newEventPeers(peers []Peer) []eventPeer {
+	j := []eventPeer{}
+	for _, p := range peers {
+		j = append(j, newEventPeer(p))
+	}
+	return j
+}
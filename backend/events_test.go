@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingObserver keeps the type of the events it receives
+type recordingObserver struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (r *recordingObserver) OnEvent(e Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *recordingObserver) types() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	types := []string{}
+	for _, e := range r.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestConnectEvents(t *testing.T) {
+	b := NewMemoryBackend()
+	d := &recordingDataplane{}
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(2)))
+
+	o := &recordingObserver{}
+	i := testInterface(b, d)
+	i.Observers = []Observer{o}
+	stop := connect(t, i)
+	waitForPeers(t, d, testPeer(2))
+
+	changed := testPeer(2)
+	changed.Endpoint = "192.168.1.2:2345"
+	require.NoError(t, b.Join(context.Background(), "wg0", changed))
+	require.Eventually(t, func() bool {
+		conf, _ := d.lastConf()
+		return len(conf.Peers) == 1 && conf.Peers[0].Endpoint == changed.Endpoint
+	}, 5*time.Second, 5*time.Millisecond)
+
+	require.NoError(t, b.Leave(context.Background(), "wg0", testPeer(2).PublicKey))
+	waitForPeers(t, d)
+	require.NoError(t, stop())
+
+	assert.Equal(t, []string{
+		EventPostUp, EventPeerAdded,
+		EventPreDown, EventPostUp, EventPeerChanged,
+		EventPreDown, EventPostUp, EventPeerRemoved,
+		EventPreDown,
+	}, o.types())
+
+	added := o.events[1]
+	assert.Equal(t, "wg0", added.Interface)
+	assert.Equal(t, "wg0", added.Network, "the network defaults to the interface name")
+	assert.Equal(t, testPeer(2), *added.Peer)
+	assert.Equal(t, []Peer{testPeer(2)}, added.Peers)
+	assert.Equal(t, []Peer{testPeer(2)}, added.Diff.Added)
+	assert.True(t, added.Initial, "the first apply")
+
+	assert.Equal(t, changed, *o.events[4].Peer)
+	assert.Equal(t, testPeer(2), *o.events[4].Previous)
+	assert.False(t, o.events[4].Initial)
+	assert.Empty(t, o.events[7].Peers)
+
+	j, err := json.Marshal(added)
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(j, &decoded))
+	assert.Equal(t, "peer-2-public-key", decoded["peer"].(map[string]interface{})["publicKey"])
+	assert.Equal(t, "10.0.0.2", decoded["peer"].(map[string]interface{})["ip"])
+	assert.WithinDuration(t, time.Now(), added.Time, time.Minute)
+}
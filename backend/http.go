@@ -2,6 +2,7 @@ package backend
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -21,14 +22,21 @@ type BasicAuth struct {
 
 // HTTPBackend ...
 type HTTPBackend struct {
-	client       *http.Client
-	baseurl      string
-	BasicAuth    *BasicAuth
+	client    *http.Client
+	baseurl   string
+	timeout   time.Duration
+	BasicAuth *BasicAuth
+	// Prefix is inserted between the base url and the paths, empty by default
+	Prefix string
+	// LegacyPrefix is inserted before the routes used before the key schema,
+	// which MigrateKeys moves under Prefix. Empty by default, the earlier
+	// versions had none.
+	LegacyPrefix string
 	wireyVersion string
 }
 
-// NewHTTPBackend ...
-func NewHTTPBackend(baseurl, wireyVersion string) (*HTTPBackend, error) {
+// NewHTTPBackend creates an http backend, timeout bounds every request made to the server
+func NewHTTPBackend(baseurl, wireyVersion string, timeout time.Duration) (*HTTPBackend, error) {
 	var transportWithTimeout = &http.Transport{
 		Dial: (&net.Dialer{
 			Timeout: 5 * time.Second,
@@ -37,28 +45,37 @@ func NewHTTPBackend(baseurl, wireyVersion string) (*HTTPBackend, error) {
 	}
 	return &HTTPBackend{
 		client: &http.Client{
-			Timeout:   time.Second * 10,
 			Transport: transportWithTimeout,
 		},
 		baseurl:      baseurl,
+		timeout:      timeout,
 		wireyVersion: wireyVersion,
 	}, nil
 }
 
+// url returns the url of a path of the key schema
+func (b *HTTPBackend) url(path string) string {
+	return fmt.Sprintf("%s/%s", b.baseurl, prefixed(b.Prefix, path))
+}
+
 // Join ...
-func (b *HTTPBackend) Join(ifname string, p Peer) error {
-	joinURL := fmt.Sprintf("%s/%s/%s", b.baseurl, ifname, utils.PublicKeySHA256(p.PublicKey))
+func (b *HTTPBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	joinURL := b.url(PeerPath(ifname, p.PublicKey))
 
 	jsonPeer, err := json.Marshal(p)
 	if err != nil {
 		return err
 	}
 
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
 	buf := bytes.NewBuffer(jsonPeer)
 	req, err := http.NewRequest("POST", joinURL, buf)
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Add("Content-Type", "application/json")
 
 	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth)
@@ -67,6 +84,7 @@ func (b *HTTPBackend) Join(ifname string, p Peer) error {
 	if err != nil {
 		return fmt.Errorf("request error during join: %s", err.Error())
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusCreated {
 		return fmt.Errorf("the join http request gave an unexpected status code: %d", res.StatusCode)
@@ -74,14 +92,46 @@ func (b *HTTPBackend) Join(ifname string, p Peer) error {
 	return nil
 }
 
+// Leave ...
+func (b *HTTPBackend) Leave(ctx context.Context, ifname string, publicKey []byte) error {
+	leaveURL := b.url(PeerPath(ifname, publicKey))
+
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("DELETE", leaveURL, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth)
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request error during leave: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return fmt.Errorf("the leave http request gave an unexpected status code: %d", res.StatusCode)
+}
+
 // GetPeers ...
-func (b *HTTPBackend) GetPeers(ifname string) ([]Peer, error) {
-	getPeersURL := fmt.Sprintf("%s/%s", b.baseurl, ifname)
+func (b *HTTPBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	getPeersURL := b.url(PeersPath(ifname))
+
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
 
 	req, err := http.NewRequest("GET", getPeersURL, nil)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 
 	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth)
 
@@ -89,6 +139,7 @@ func (b *HTTPBackend) GetPeers(ifname string) ([]Peer, error) {
 	if err != nil {
 		return nil, fmt.Errorf("request error during get peers: %s", err.Error())
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("the get peers http request gave an unexpected status code: %d", res.StatusCode)
@@ -104,6 +155,205 @@ func (b *HTTPBackend) GetPeers(ifname string) ([]Peer, error) {
 	return peers, nil
 }
 
+// GetSettings ...
+func (b *HTTPBackend) GetSettings(ctx context.Context, ifname string) (*Settings, error) {
+	settingsURL := b.url(SettingsPath(ifname))
+
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", settingsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth)
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request error during get settings: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("the get settings http request gave an unexpected status code: %d", res.StatusCode)
+	}
+
+	s := &Settings{}
+	if err := json.NewDecoder(res.Body).Decode(s); err != nil {
+		return nil, fmt.Errorf("error decoding the settings during get settings: %s", err.Error())
+	}
+	return s, nil
+}
+
+// PutSettings ...
+func (b *HTTPBackend) PutSettings(ctx context.Context, ifname string, s Settings) error {
+	settingsURL := b.url(SettingsPath(ifname))
+
+	jsonSettings, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("PUT", settingsURL, bytes.NewBuffer(jsonSettings))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("Content-Type", "application/json")
+
+	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth)
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request error during put settings: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	}
+	return fmt.Errorf("the put settings http request gave an unexpected status code: %d", res.StatusCode)
+}
+
+// MigrateKeys moves the records served by the routes used before the key
+// schema: the peers of GET {base}/{legacy prefix}/{network}, removed with
+// DELETE {base}/{legacy prefix}/{network}/{sha}, and the settings of GET and
+// DELETE {base}/{legacy prefix}/{network}/_config. A server without these
+// routes has nothing to migrate.
+func (b *HTTPBackend) MigrateKeys(ctx context.Context, network string, opts MigrateOptions) ([]string, error) {
+	legacyURL := fmt.Sprintf("%s/%s", b.baseurl, prefixed(b.LegacyPrefix, network))
+	keys := []string{}
+
+	legacy := []Peer{}
+	found, err := b.getJSON(ctx, legacyURL, &legacy)
+	if err != nil || !found {
+		return keys, err
+	}
+	existing := map[string]bool{}
+	if !opts.DryRun && len(legacy) > 0 {
+		current, err := b.GetPeers(ctx, network)
+		if err != nil {
+			return keys, err
+		}
+		for _, p := range current {
+			existing[utils.PublicKeySHA256(p.PublicKey)] = true
+		}
+	}
+
+	for _, p := range legacy {
+		sha := utils.PublicKeySHA256(p.PublicKey)
+		key := fmt.Sprintf("%s/%s", legacyURL, sha)
+		keys = append(keys, key)
+		if opts.DryRun {
+			continue
+		}
+		if !existing[sha] {
+			if err := b.Join(ctx, network, p); err != nil {
+				return keys, err
+			}
+		}
+		if opts.Keep {
+			continue
+		}
+		if err := b.delete(ctx, key); err != nil {
+			return keys, err
+		}
+	}
+
+	settingsURL := fmt.Sprintf("%s/%s", legacyURL, settingsName)
+	settings := Settings{}
+	found, err = b.getJSON(ctx, settingsURL, &settings)
+	if err != nil || !found {
+		return keys, err
+	}
+	keys = append(keys, settingsURL)
+	if opts.DryRun {
+		return keys, nil
+	}
+	current, err := b.GetSettings(ctx, network)
+	if err != nil {
+		return keys, err
+	}
+	if current == nil {
+		if err := b.PutSettings(ctx, network, settings); err != nil {
+			return keys, err
+		}
+	}
+	if opts.Keep {
+		return keys, nil
+	}
+	return keys, b.delete(ctx, settingsURL)
+}
+
+// getJSON decodes the answer of a GET into v, it returns false when the
+// server answers 404 Not Found
+func (b *HTTPBackend) getJSON(ctx context.Context, url string, v interface{}) (bool, error) {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+
+	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth)
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("request error during get %s: %s", url, err.Error())
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("the get %s http request gave an unexpected status code: %d", url, res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("error decoding %s: %s", url, err.Error())
+	}
+	return true, nil
+}
+
+// delete removes the record at url, a missing record is not an error
+func (b *HTTPBackend) delete(ctx context.Context, url string) error {
+	ctx, cancel := withTimeout(ctx, b.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	injectCommonHeaders(req, b.wireyVersion, b.BasicAuth)
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request error during delete %s: %s", url, err.Error())
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return fmt.Errorf("the delete %s http request gave an unexpected status code: %d", url, res.StatusCode)
+}
+
 func injectCommonHeaders(req *http.Request, wireyVersion string, basicAuth *BasicAuth) {
 	req.Header.Add("User-Agent", fmt.Sprintf("%s/%s", httpUserAgent, wireyVersion))
 
@@ -0,0 +1,128 @@
+package backend_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"wirey/backend"
+	"wirey/pkg/utils"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLegacyHTTP serves the legacy routes of a network next to the key schema
+type fakeLegacyHTTP struct {
+	mutex    sync.Mutex
+	network  string
+	legacy   map[string][]byte
+	settings map[string][]byte
+	peers    map[string][]byte
+}
+
+func (f *fakeLegacyHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	peersPath := backend.PeersPath(f.network)
+	settingsPath := backend.SettingsPath(f.network)
+	legacyPath := f.network
+
+	store := f.legacy
+	name := strings.TrimPrefix(path, legacyPath+"/")
+	switch {
+	case path == settingsPath:
+		store, name = f.settings, path
+	case path == peersPath || strings.HasPrefix(path, peersPath+"/"):
+		store, name = f.peers, strings.TrimPrefix(path, peersPath+"/")
+	case path == legacyPath && r.Method == http.MethodGet:
+		list(w, f.legacy, "_config")
+		return
+	case !strings.HasPrefix(path, legacyPath+"/"):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if path == peersPath {
+			list(w, f.peers, "")
+			return
+		}
+		v, ok := store[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(v)
+	case http.MethodPost, http.MethodPut:
+		v, _ := ioutil.ReadAll(r.Body)
+		store[name] = v
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		delete(store, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list writes the values of store, but skip, as a json array
+func list(w http.ResponseWriter, store map[string][]byte, skip string) {
+	values := []json.RawMessage{}
+	for k, v := range store {
+		if k != skip {
+			values = append(values, v)
+		}
+	}
+	json.NewEncoder(w).Encode(values)
+}
+
+func TestHTTPBackendMigrateKeys(t *testing.T) {
+	ip := net.IPv4(10, 0, 0, 2)
+	peer := backend.Peer{PublicKey: []byte("peer-2-public-key\n"), Endpoint: "192.168.0.2:2345", IP: &ip}
+	pj, err := json.Marshal(peer)
+	require.NoError(t, err)
+
+	sha := utils.PublicKeySHA256(peer.PublicKey)
+	fake := &fakeLegacyHTTP{
+		network:  "net",
+		legacy:   map[string][]byte{sha: pj, "_config": []byte(`{"mtu":1420}`)},
+		settings: map[string][]byte{},
+		peers:    map[string][]byte{},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	b, err := backend.NewHTTPBackend(server.URL, "test", 5*time.Second)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	keys, err := b.MigrateKeys(ctx, "net", backend.MigrateOptions{DryRun: true})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{server.URL + "/net/" + sha, server.URL + "/net/_config"}, keys)
+	require.Len(t, fake.legacy, 2, "a dry run changes nothing")
+
+	_, err = b.MigrateKeys(ctx, "net", backend.MigrateOptions{})
+	require.NoError(t, err)
+	require.Empty(t, fake.legacy, "the legacy peers and settings are removed")
+
+	peers, err := b.GetPeers(ctx, "net")
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	require.Equal(t, peer.PublicKey, peers[0].PublicKey)
+
+	s, err := b.GetSettings(ctx, "net")
+	require.NoError(t, err)
+	require.Equal(t, &backend.Settings{MTU: 1420}, s)
+
+	keys, err = b.MigrateKeys(ctx, "net", backend.MigrateOptions{})
+	require.NoError(t, err)
+	require.Empty(t, keys, "the records are in the key schema")
+}
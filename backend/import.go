@@ -0,0 +1,45 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+
+	"wirey/pkg/wireguard"
+)
+
+const (
+	errImportNoAddress = "peer %s has no /32 allowed ip to use as its address inside the tunnel"
+)
+
+// StaticPeers converts the peers of an existing wireguard device into static
+// peers. The first /32 in the allowed ips of each peer becomes its ip,
+// the other allowed ips are kept as they are.
+func StaticPeers(device *wireguard.Device) ([]Peer, error) {
+	peers := []Peer{}
+	for _, dp := range device.Peers {
+		peer := Peer{
+			// same format as the keys produced by `wg pubkey`
+			PublicKey:  []byte(dp.PublicKey + "\n"),
+			Endpoint:   dp.Endpoint,
+			AllowedIPs: []string{},
+			Static:     true,
+		}
+		for _, allowedIP := range dp.AllowedIPs {
+			ip, ipnet, err := net.ParseCIDR(allowedIP)
+			if err != nil {
+				return nil, fmt.Errorf("peer %s: %s", dp.PublicKey, err.Error())
+			}
+			// wirey addresses the peers with ipv4 only
+			if ones, _ := ipnet.Mask.Size(); peer.IP == nil && ip.To4() != nil && ones == 32 {
+				peer.IP = &ip
+				continue
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs, allowedIP)
+		}
+		if peer.IP == nil {
+			return nil, fmt.Errorf(errImportNoAddress, dp.PublicKey)
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
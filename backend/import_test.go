@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"net"
+	"testing"
+
+	"wirey/pkg/wireguard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticPeers(t *testing.T) {
+	peers, err := StaticPeers(&wireguard.Device{
+		Peers: []wireguard.DevicePeer{
+			{
+				PublicKey:  "nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=",
+				Endpoint:   "172.31.23.162:2345",
+				AllowedIPs: []string{"10.10.0.0/16", "10.0.0.2/32", "10.0.0.20/32"},
+			},
+			{
+				PublicKey:  "59Je0kMsYkWkQ52Rt7o9Ss60QP3fTcoTQgJgsWDW/QQ=",
+				AllowedIPs: []string{"10.0.0.3/32"},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	ip2 := net.ParseIP("10.0.0.2")
+	ip3 := net.ParseIP("10.0.0.3")
+	assert.Equal(t, []Peer{
+		{
+			PublicKey:  []byte("nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=\n"),
+			Endpoint:   "172.31.23.162:2345",
+			IP:         &ip2,
+			AllowedIPs: []string{"10.10.0.0/16", "10.0.0.20/32"},
+			Static:     true,
+		},
+		{
+			PublicKey:  []byte("59Je0kMsYkWkQ52Rt7o9Ss60QP3fTcoTQgJgsWDW/QQ=\n"),
+			IP:         &ip3,
+			AllowedIPs: []string{},
+			Static:     true,
+		},
+	}, peers)
+
+	// a static peer without endpoint is configured without one
+	conf, err := NewConfiguration(testPeer(1), []byte("local-private-key"), peers)
+	require.NoError(t, err)
+	rendered, err := wireguard.RenderConfiguration(conf)
+	require.NoError(t, err)
+	assert.NotContains(t, string(rendered), "Endpoint = \n")
+}
+
+func TestStaticPeersWithoutAddress(t *testing.T) {
+	_, err := StaticPeers(&wireguard.Device{
+		Peers: []wireguard.DevicePeer{
+			{PublicKey: "nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=", AllowedIPs: []string{"10.10.0.0/16"}},
+		},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"context"
+	"sync"
+
+	"wirey/pkg/utils"
+)
+
+// MemoryBackend keeps the peers in memory, it is only shared inside the
+// same process and it is meant for tests and for embedding wirey.
+type MemoryBackend struct {
+	mutex    sync.RWMutex
+	peers    map[string]map[string]Peer
+	settings map[string]Settings
+}
+
+// NewMemoryBackend ...
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		peers:    map[string]map[string]Peer{},
+		settings: map[string]Settings{},
+	}
+}
+
+// Join ...
+func (m *MemoryBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.peers[ifname]; !ok {
+		m.peers[ifname] = map[string]Peer{}
+	}
+	m.peers[ifname][utils.PublicKeySHA256(p.PublicKey)] = p
+	return nil
+}
+
+// Leave ...
+func (m *MemoryBackend) Leave(ctx context.Context, ifname string, publicKey []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.peers[ifname], utils.PublicKeySHA256(publicKey))
+	return nil
+}
+
+// GetPeers ...
+func (m *MemoryBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	peers := []Peer{}
+	for _, p := range m.peers[ifname] {
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+// GetSettings ...
+func (m *MemoryBackend) GetSettings(ctx context.Context, ifname string) (*Settings, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	s, ok := m.settings[ifname]
+	if !ok {
+		return nil, nil
+	}
+	s.AllowedIPs = append([]string(nil), s.AllowedIPs...)
+	return &s, nil
+}
+
+// PutSettings ...
+func (m *MemoryBackend) PutSettings(ctx context.Context, ifname string, s Settings) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s.AllowedIPs = append([]string(nil), s.AllowedIPs...)
+	m.settings[ifname] = s
+	return nil
+}
@@ -0,0 +1,12 @@
+package backend_test
+
+import (
+	"testing"
+
+	"wirey/backend"
+	"wirey/backend/backendtest"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	backendtest.Run(t, backend.NewMemoryBackend())
+}
@@ -2,8 +2,7 @@ package backend
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -12,12 +11,13 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/cenkalti/backoff/v4"
-	"github.com/vishvananda/netlink"
+	"wirey/pkg/dataplane"
 	"wirey/pkg/wireguard"
 )
 
@@ -34,6 +34,7 @@ const (
 	errAddressAlreadyTaken    = "address already taken: %s"
 	errAddLink                = "error adding the wireguard link: %s"
 	errIntConversionPort      = "error during port conversion to int: %s"
+	errUsingCachedPeers       = "using the cached peers until the backend is reachable"
 )
 
 // values used for exponentialBackoff
@@ -41,6 +42,9 @@ const (
 	MaxElapsedTime = 15 * time.Minute
 	MaxInterval    = 120 * time.Second
 	JitterRange    = 5
+	// MaxRetries bounds the attempts of a single reconcile step, after that
+	// the interface is marked as degraded until the next check
+	MaxRetries = 5
 )
 
 // Peer ...
@@ -49,16 +53,71 @@ type Peer struct {
 	Endpoint   string
 	IP         *net.IP
 	AllowedIPs []string
+	// Name is an optional label, e.g. for the peers registered from the cli
+	Name string `json:",omitempty"`
+	// Static peers are not managed by wirey, e.g. imported from an existing
+	// configuration: nothing refreshes their record
+	Static bool `json:",omitempty"`
 }
 
 // Interface ...
 type Interface struct {
-	Backend      Backend
-	Name         string
+	Backend Backend
+	// Name is the name of the local device
+	Name string
+	// Network is the name of the network in the backend, shared by all its
+	// peers. The device Name is used when it is empty.
+	Network      string
 	PeerCheckTTL time.Duration
 	LocalPeer    Peer
-	privateKey   []byte
-	retries      int
+	// Dataplane applies the configuration to the local device
+	Dataplane dataplane.Dataplane
+	// MTU of the link, the dataplane default when zero
+	MTU int
+	// PeerCache, when set, keeps the last applied peers on disk
+	PeerCache *PeerCache
+	// OnReload, when set, is called by Reload to re-read the configuration
+	// of the interface, before joining the backend again
+	OnReload func(i *Interface) error
+	// OnJoin, when set, is called by every join before the address check,
+	// so the local peer registered follows what it changes. Its errors are
+	// retried like the backend ones.
+	OnJoin func(ctx context.Context, i *Interface) error
+	// ApplyPolicy limits how often the peer changes are applied
+	ApplyPolicy ApplyPolicy
+	// Recorder, when set, receives the measurements of the reconcile loop
+	Recorder Recorder
+	// Observers receive the events of the link and of its peers
+	Observers  []Observer
+	privateKey []byte
+
+	commandsOnce sync.Once
+	commandsCh   chan command
+
+	mu        sync.RWMutex
+	state     State
+	lastError error
+	joined    bool
+	// left is set by Leave, the node stays out of the backend until Rejoin
+	left       bool
+	lastSync   time.Time
+	backendErr error
+	// backendErrSince is when the backend started failing
+	backendErrSince time.Time
+	applied         []Peer
+	linkUp          bool
+	// configured is set once peers have been applied since Connect started
+	configured   bool
+	lastActivity time.Time
+	nextActivity time.Duration
+
+	// throttle and holdFor are only used by the Connect loop, holdFor is
+	// how long a held change waits before being applied
+	throttle throttle
+	holdFor  time.Duration
+	// split is the last split between the network and the device name
+	// that was logged, see checkSplit
+	split string
 }
 
 // NewInterface ...
@@ -117,6 +176,7 @@ func NewInterface(
 		Backend:      b,
 		Name:         ifname,
 		PeerCheckTTL: peerCheckTTL,
+		Dataplane:    dataplane.NewKernel(),
 		privateKey:   privKey,
 		LocalPeer: Peer{
 			PublicKey:  pubKey,
@@ -127,32 +187,138 @@ func NewInterface(
 	}, nil
 }
 
-func extractPeersSHA(workingPeers []Peer) string {
-	sort.Slice(workingPeers, func(i, j int) bool {
-		comparison := bytes.Compare(workingPeers[i].PublicKey, workingPeers[j].PublicKey)
-		if comparison > 0 {
-			return true
+// network returns the name of the network in the backend
+func (i *Interface) network() string {
+	if i.Network == "" {
+		return i.Name
+	}
+	return i.Network
+}
+
+// migrate copies the records stored under the device name, which was the
+// network name of the earlier versions, to an empty network. The old
+// records stay for the nodes that still use them, the first node joining
+// the network moves the others. The peers registered under the device name
+// afterwards are not copied, see checkSplit.
+func (i *Interface) migrate(ctx context.Context) error {
+	if err := i.migrateKeys(ctx, i.Name); err != nil {
+		return err
+	}
+	if i.network() == i.Name {
+		return nil
+	}
+	if err := i.migrateKeys(ctx, i.network()); err != nil {
+		return err
+	}
+	peers, err := i.Backend.GetPeers(ctx, i.network())
+	if err != nil || len(peers) > 0 {
+		return err
+	}
+	legacy, err := i.Backend.GetPeers(ctx, i.Name)
+	if err != nil {
+		return err
+	}
+	for _, p := range legacy {
+		if err := i.Backend.Join(ctx, i.network(), p); err != nil {
+			return err
+		}
+	}
+
+	if store, ok := i.Backend.(SettingsStore); ok {
+		if err := migrateSettings(ctx, store, i.Name, i.network()); err != nil {
+			return err
+		}
+	}
+
+	if len(legacy) > 0 {
+		log.Infof("Migrated %d peers from %s to the network %s", len(legacy), i.Name, i.network())
+	}
+	return nil
+}
+
+// migrateKeys copies the records of network still using the legacy keys to
+// the key schema while it has no peers. The legacy records stay for the
+// nodes not upgraded yet, wirey migrate drops them once every node is.
+func (i *Interface) migrateKeys(ctx context.Context, network string) error {
+	m, ok := AsKeyMigrator(i.Backend)
+	if !ok {
+		return nil
+	}
+	peers, err := i.Backend.GetPeers(ctx, network)
+	if err != nil || len(peers) > 0 {
+		return err
+	}
+	// the copy is best effort, a server without the legacy routes may
+	// answer anything
+	keys, err := m.MigrateKeys(ctx, network, MigrateOptions{Keep: true})
+	if err != nil {
+		log.Warnf("unable to copy the legacy records of %s: %s", network, err.Error())
+		return nil
+	}
+	if len(keys) > 0 {
+		log.Infof("Copied %d legacy records of %s to the key schema", len(keys), network)
+	}
+	return nil
+}
+
+// checkSplit logs the peers registered under the device name that are not
+// part of the network, peers is the network. These nodes still run with the
+// network name of the earlier versions: they do not see the network and the
+// network does not see them. They are not copied, a peer removed from the
+// network would come back from its old record. Nothing is logged until the
+// split changes.
+func (i *Interface) checkSplit(ctx context.Context, peers []Peer) {
+	if i.network() == i.Name {
+		return
+	}
+	legacy, err := i.Backend.GetPeers(ctx, i.Name)
+	if err != nil {
+		log.Debugf("unable to read the peers registered under %s: %s", i.Name, err.Error())
+		return
+	}
+
+	joined := map[string]bool{}
+	for _, p := range peers {
+		joined[peerKey(p)] = true
+	}
+	outside := []string{}
+	for _, p := range legacy {
+		if !joined[peerKey(p)] {
+			outside = append(outside, peerKey(p))
 		}
-		return false
-	})
-	keys := ""
-	for _, p := range workingPeers {
-		// hash the full peer to verify if it changed
-		peerj, _ := json.Marshal(p)
-		peerh := sha256.New()
-		peerh.Write(peerj)
-		keys = fmt.Sprintf("%s%x", keys, peerh.Sum(nil))
-	}
-
-	// hash of all the peers
-	h := sha256.New()
-	h.Write([]byte(keys))
-
-	return fmt.Sprintf("%x", h.Sum(nil))
+	}
+	sort.Strings(outside)
+
+	split := fmt.Sprintf("%d %s", len(legacy), strings.Join(outside, ","))
+	if split == i.split {
+		return
+	}
+	i.split = split
+	switch {
+	case len(outside) > 0:
+		log.Warnf("%d peers are registered under %s but not in the network %s, they do not see this node until they run with --network-name %s: %s",
+			len(outside), i.Name, i.network(), i.network(), strings.Join(outside, ", "))
+	case len(legacy) > 0:
+		log.Infof("Every peer registered under %s is in the network %s, remove the %d old records with wirey peers remove --network-name %s",
+			i.Name, i.network(), len(legacy), i.Name)
+	}
+}
+
+// migrateSettings copies the settings of from, unless to has its own
+func migrateSettings(ctx context.Context, store SettingsStore, from, to string) error {
+	current, err := store.GetSettings(ctx, to)
+	if err != nil || current != nil {
+		return err
+	}
+	settings, err := store.GetSettings(ctx, from)
+	if err != nil || settings == nil {
+		return err
+	}
+	return store.PutSettings(ctx, to, *settings)
 }
 
-func (i *Interface) addressAlreadyTaken() (bool, error) {
-	peers, err := i.Backend.GetPeers(i.Name)
+func (i *Interface) addressAlreadyTaken(ctx context.Context) (bool, error) {
+	peers, err := i.Backend.GetPeers(ctx, i.network())
 	if err != nil {
 		return false, err
 	}
@@ -164,137 +330,470 @@ func (i *Interface) addressAlreadyTaken() (bool, error) {
 	return false, nil
 }
 
-// Connect ...
-func (i *Interface) Connect() error {
+// Connect joins the backend and keeps the local wireguard interface in sync
+// with the peers found there until the context is cancelled.
+func (i *Interface) Connect(ctx context.Context) error {
 	rand.Seed(time.Now().UnixNano())
-	initialInterval := rand.Intn(JitterRange) + 1
-	exp := backoff.NewExponentialBackOff()
-	exp.MaxElapsedTime = MaxElapsedTime
-	exp.MaxInterval = MaxInterval
-	exp.InitialInterval = time.Duration(initialInterval) * time.Second
 
-	notify := func(err error, time time.Duration) {
-		log.Warnf("wirey error %+v, retrying in %s\n", err, time)
+	i.setState(StateJoining, nil)
+	i.tick(MaxInterval)
+	i.mu.Lock()
+	i.configured = false
+	i.mu.Unlock()
+	defer i.stop()
+	known := i.restore()
+
+	// while running from the cache the tunnel is already up, so there is
+	// no reason to give up waiting for the backend
+	maxElapsedTime := MaxElapsedTime
+	if known != nil {
+		maxElapsedTime = 0
 	}
-	err := backoff.RetryNotify(func() error {
-		taken, err := i.addressAlreadyTaken()
-		if taken {
-			exp.MaxElapsedTime = backoff.Stop
-			return fmt.Errorf(errAddressAlreadyTaken, *i.LocalPeer.IP)
+
+	if err := i.join(ctx, 0, maxElapsedTime); err != nil {
+		if ctx.Err() != nil {
+			return nil
 		}
 		return err
-	}, exp, notify)
-
-	if err != nil {
-		return fmt.Errorf("error %+v", err)
 	}
 
-	// Join
-	err = i.Backend.Join(i.Name, i.LocalPeer)
+	// pending is the command waiting for the result of the next sync
+	var pending *command
+	for {
+		err := i.sync(ctx, &known)
+		if ctx.Err() != nil {
+			if pending != nil {
+				pending.done <- ctx.Err()
+			}
+			log.Infoln("Shutting down")
+			return nil
+		}
+		if pending != nil {
+			pending.done <- err
+			pending = nil
+		}
 
+		next := i.PeerCheckTTL
+		if i.holdFor > 0 && i.holdFor < next {
+			next = i.holdFor
+		}
+		i.tick(next)
+		select {
+		case <-ctx.Done():
+			log.Infoln("Shutting down")
+			return nil
+		case <-time.After(next):
+		case c := <-i.commands():
+			log.Infof("Received the %s command", c.name)
+			if err := i.run(ctx, c.name); err != nil {
+				c.done <- err
+			} else {
+				pending = &c
+			}
+			// apply the peers again even if they did not change
+			known = nil
+		}
+	}
+}
+
+// sync runs a reconcile and updates the state accordingly
+func (i *Interface) sync(ctx context.Context, known *[]Peer) error {
+	start := time.Now()
+	peers, err := i.reconcile(ctx, *known)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	i.recorder().Reconcile(time.Since(start), err)
 	if err != nil {
+		log.Errorf("reconcile failed, keeping the previous configuration: %s", err.Error())
+		i.setState(StateDegraded, err)
 		return err
 	}
+	*known = peers
+	i.setState(StateApplied, nil)
+	return nil
+}
 
-	peersSHA := ""
-	allowedIps := ""
+// State returns the current state of the reconcile loop and the error
+// that caused it to be degraded, if any.
+func (i *Interface) State() (State, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.state, i.lastError
+}
 
-	for {
-		var workingPeers []Peer
-		err := backoff.RetryNotify(func() error {
-			workingPeers, err = i.Backend.GetPeers(i.Name)
-			if err != nil {
-				return fmt.Errorf("problem during extraction of peers from backend: %s", err)
+func (i *Interface) setState(s State, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.state = s
+	i.lastError = err
+}
+
+// join registers the local peer, checking first that its address is free.
+// maxRetries and maxElapsedTime bound the wait for the backend, both steps
+// retry forever when they are zero.
+func (i *Interface) join(ctx context.Context, maxRetries uint64, maxElapsedTime time.Duration) error {
+	err := backoff.RetryNotify(func() error {
+		err := i.migrate(ctx)
+		i.setBackendError(err)
+		if err != nil {
+			return err
+		}
+		if i.OnJoin != nil {
+			if err := i.OnJoin(ctx, i); err != nil {
+				return err
 			}
+		}
+		taken, err := i.addressAlreadyTaken(ctx)
+		i.setBackendError(err)
+		if err != nil {
 			return err
-		}, exp, notify)
-
-		// We don't change anything if the peers remain the same
-		newPeersSHA := extractPeersSHA(workingPeers)
-		if newPeersSHA == peersSHA {
-			log.Debugf("Peers matched, sleeping for %s \n", i.PeerCheckTTL)
-			time.Sleep(i.PeerCheckTTL)
-			continue
 		}
-		log.Infoln("The peer list changed, reconfiguring...")
-		peersSHA = newPeersSHA
-
-		// delete any old link
-		link, _ := netlink.LinkByName(i.Name)
-		if link != nil {
-			log.Infoln("Delete old link")
-			netlink.LinkDel(link)
+		if taken {
+			return backoff.Permanent(fmt.Errorf(errAddressAlreadyTaken, *i.LocalPeer.IP))
 		}
+		return nil
+	}, newBackOff(ctx, maxRetries, maxElapsedTime), i.notifyRetry)
 
-		// create the actual link
-		wirelink := &netlink.GenericLink{
-			LinkAttrs: netlink.LinkAttrs{
-				Name: i.Name,
-			},
-			LinkType: "wireguard",
-		}
-		err = netlink.LinkAdd(wirelink)
+	if err != nil {
+		return fmt.Errorf("error %+v", err)
+	}
+
+	err = backoff.RetryNotify(func() error {
+		err := i.Backend.Join(ctx, i.network(), i.LocalPeer)
+		i.setBackendError(err)
+		return err
+	}, newBackOff(ctx, maxRetries, maxElapsedTime), i.notifyRetry)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	i.joined = true
+	i.mu.Unlock()
+	return nil
+}
+
+// reconcile fetches the peers from the backend and applies them when they
+// differ from the known ones, returning the peers now applied. When known
+// is nil the link is recreated with the peers even if they did not change.
+func (i *Interface) reconcile(ctx context.Context, known []Peer) ([]Peer, error) {
+	workingPeers := []Peer{}
+	err := backoff.RetryNotify(func() error {
+		peers, err := i.Backend.GetPeers(ctx, i.network())
+		i.setBackendError(err)
 		if err != nil {
-			log.Errorf(errAddLink, err.Error())
-			return i.Connect()
+			return fmt.Errorf("problem during extraction of peers from backend: %s", err)
 		}
+		workingPeers = append(workingPeers[:0], peers...)
+		return nil
+	}, newBackOff(ctx, MaxRetries, MaxElapsedTime), i.notifyRetry)
 
-		// Add the actual address to the link
-		addr, err := netlink.ParseAddr(fmt.Sprintf("%s/24", i.LocalPeer.IP.String()))
-		if err != nil {
-			log.Errorf("error parsing the new ip address: %s", err.Error())
-			return i.Connect()
+	if err != nil {
+		return known, err
+	}
+
+	i.mu.Lock()
+	i.lastSync = time.Now()
+	i.mu.Unlock()
+	i.checkSplit(ctx, workingPeers)
+
+	// We don't change anything if the peers remain the same, the record
+	// of the local peer is not part of the wireguard configuration
+	now := time.Now()
+	i.holdFor = 0
+	peers := i.throttle.damp(i.ApplyPolicy, i.remotePeers(workingPeers), i.Status().Applied, now)
+	diff := DiffPeers(i.remotePeers(known), peers)
+	if known != nil && diff.Empty() {
+		i.throttle.clear()
+		log.Debugf("Peers matched, sleeping for %s \n", i.PeerCheckTTL)
+		return known, nil
+	}
+	if known != nil {
+		if wait := i.throttle.wait(i.ApplyPolicy, peers, now); wait > 0 {
+			log.Infof("The peer list changed (%s), applying in %s", diff, wait.Round(time.Millisecond))
+			i.holdFor = wait
+			return known, nil
 		}
+	}
+	log.Infof("The peer list changed (%s), reconfiguring...", diff)
+	i.setState(StateSyncing, nil)
 
-		// Configure wireguard
-		s := strings.Split(i.LocalPeer.Endpoint, ":")
-		port, err := strconv.Atoi(s[1])
-		if err != nil {
-			return fmt.Errorf(errIntConversionPort, err.Error())
+	err = backoff.RetryNotify(func() error {
+		if known == nil {
+			return i.configure(peers)
 		}
-		conf := wireguard.Configuration{
-			Interface: wireguard.Interface{
-				ListenPort: port,
-				PrivateKey: string(i.privateKey),
-			},
-			Peers: []wireguard.Peer{},
+		return i.apply(peers)
+	}, newBackOff(ctx, MaxRetries, MaxElapsedTime), i.notifyRetry)
+
+	if err != nil {
+		return known, err
+	}
+	i.throttle.applied(time.Now())
+
+	if i.PeerCache != nil {
+		if err := i.PeerCache.Store(peers); err != nil {
+			log.Warnf("unable to cache the applied peers: %s", err.Error())
 		}
+	}
+	return peers, nil
+}
 
-		for _, p := range workingPeers {
-			if bytes.Equal(p.PublicKey, i.LocalPeer.PublicKey) {
-				continue
-			}
+// restore brings the interface up with the cached peers, so that the
+// tunnel works while the backend is not reachable. It returns the restored
+// peers, or nil when nothing was restored.
+func (i *Interface) restore() []Peer {
+	if i.PeerCache == nil {
+		return nil
+	}
 
-			if len(p.AllowedIPs) > 0 {
-				allowedIps = fmt.Sprintf("%s/32,%s", p.IP.String(), strings.Join(p.AllowedIPs[:], ","))
-			} else {
-				allowedIps = fmt.Sprintf("%s/32", p.IP.String())
-			}
+	peers, err := i.PeerCache.Load()
+	if err != nil {
+		log.Warnf("unable to load the cached peers from %s: %s", i.PeerCache.Path, err.Error())
+		return nil
+	}
+	if len(peers) == 0 {
+		return nil
+	}
+
+	log.Infof("Restoring %d cached peers from %s", len(peers), i.PeerCache.Path)
+	if err := i.configure(peers); err != nil {
+		log.Warnf("unable to restore the cached peers: %s", err.Error())
+		return nil
+	}
+
+	i.setState(StateDegraded, fmt.Errorf(errUsingCachedPeers))
+	return peers
+}
 
-			conf.Peers = append(conf.Peers, wireguard.Peer{
-				PublicKey:  string(p.PublicKey),
-				AllowedIPs: allowedIps,
-				Endpoint:   p.Endpoint,
-			})
+// NewConfiguration builds the wireguard configuration of the local peer,
+// every other peer in peers is configured as a wireguard peer.
+func NewConfiguration(local Peer, privateKey []byte, peers []Peer) (wireguard.Configuration, error) {
+	s := strings.Split(local.Endpoint, ":")
+	if len(s) != 2 {
+		return wireguard.Configuration{}, fmt.Errorf(errEndpointFormatNotValid)
+	}
+	port, err := strconv.Atoi(s[1])
+	if err != nil {
+		return wireguard.Configuration{}, fmt.Errorf(errIntConversionPort, err.Error())
+	}
+	conf := wireguard.Configuration{
+		Interface: wireguard.Interface{
+			ListenPort: port,
+			PrivateKey: string(privateKey),
+		},
+		Peers: []wireguard.Peer{},
+	}
+
+	allowedIps := ""
+	for _, p := range peers {
+		if bytes.Equal(p.PublicKey, local.PublicKey) {
+			continue
 		}
 
-		_, err = wireguard.SetConf(i.Name, conf)
+		if len(p.AllowedIPs) > 0 {
+			allowedIps = fmt.Sprintf("%s/32,%s", p.IP.String(), strings.Join(p.AllowedIPs[:], ","))
+		} else {
+			allowedIps = fmt.Sprintf("%s/32", p.IP.String())
+		}
 
-		if err != nil {
-			return i.Connect()
+		conf.Peers = append(conf.Peers, wireguard.Peer{
+			PublicKey:  string(p.PublicKey),
+			AllowedIPs: allowedIps,
+			Endpoint:   p.Endpoint,
+		})
+	}
+	return conf, nil
+}
+
+// LinkAddress returns the address, in CIDR notation, assigned to the link
+// of a peer with the given ip
+func LinkAddress(ip net.IP) string {
+	return fmt.Sprintf("%s/24", ip.String())
+}
+
+// configure recreates the wireguard link with the provided peers
+func (i *Interface) configure(workingPeers []Peer) error {
+	cidr := LinkAddress(*i.LocalPeer.IP)
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return backoff.Permanent(fmt.Errorf("error parsing the new ip address: %s", err.Error()))
+	}
+
+	// Configure wireguard
+	conf, err := NewConfiguration(i.LocalPeer, i.privateKey, workingPeers)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+
+	// the link is replaced
+	if s := i.Status(); s.LinkUp {
+		i.notify(Event{Type: EventPreDown, Peers: s.Applied})
+	}
+
+	// create the actual link
+	if err := i.Dataplane.LinkAdd(i.Name); err != nil {
+		return fmt.Errorf(errAddLink, err.Error())
+	}
+	i.recorder().LinkCreated()
+
+	if err := i.Dataplane.SetConf(i.Name, conf); err != nil {
+		return err
+	}
+
+	// Add the actual address to the link
+	if err := i.Dataplane.AddrAdd(i.Name, cidr); err != nil {
+		return fmt.Errorf("failed to add address to link: %s", err.Error())
+	}
+
+	if i.MTU > 0 {
+		if m, ok := i.Dataplane.(dataplane.MTUSetter); ok {
+			if err := m.LinkSetMTU(i.Name, i.MTU); err != nil {
+				return fmt.Errorf("failed to set the mtu of the link: %s", err.Error())
+			}
+		} else {
+			log.Warnf("The dataplane cannot change the mtu of the link, ignoring mtu %d", i.MTU)
 		}
+	}
 
-		netlink.AddrAdd(wirelink, addr)
+	// Up the link
+	if err := i.Dataplane.LinkUp(i.Name); err != nil {
+		i.mu.Lock()
+		i.linkUp = false
+		i.mu.Unlock()
+		return fmt.Errorf("failed to setup link: %s", err.Error())
+	}
 
-		// Up the link
-		err = netlink.LinkSetUp(wirelink)
-		if err != nil {
-			log.Errorf("failed to setup link: %s", err.Error())
-			return err
+	log.Println("Link up")
+
+	i.setApplied(workingPeers, true)
+	return nil
+}
+
+// apply changes only the peers that differ from the applied ones when the
+// link is up and the dataplane can update it in place, otherwise the link
+// is recreated
+func (i *Interface) apply(workingPeers []Peer) error {
+	updater, ok := i.Dataplane.(dataplane.PeerUpdater)
+	s := i.Status()
+	if !ok || !s.LinkUp {
+		return i.configure(workingPeers)
+	}
+
+	diff := DiffPeers(s.Applied, i.remotePeers(workingPeers))
+	if diff.Empty() {
+		i.setApplied(workingPeers, false)
+		return nil
+	}
+	changed := append([]Peer{}, diff.Added...)
+	// a set peer keeps the endpoint the device has, the peers whose endpoint
+	// went away are removed and added again
+	reset := []string{}
+	for _, c := range diff.Changed {
+		changed = append(changed, c.New)
+		if c.Old.Endpoint != "" && c.New.Endpoint == "" {
+			reset = append(reset, string(c.New.PublicKey))
 		}
+	}
+	conf, err := NewConfiguration(i.LocalPeer, i.privateKey, changed)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	remove := []string{}
+	for _, p := range diff.Removed {
+		remove = append(remove, string(p.PublicKey))
+	}
 
-		log.Println("Link up")
+	if len(reset) > 0 {
+		if err := updater.UpdatePeers(i.Name, nil, reset); err != nil {
+			log.Warnf("unable to reset the endpoint of the peers, recreating the link: %s", err.Error())
+			return i.configure(workingPeers)
+		}
 	}
+	if err := updater.UpdatePeers(i.Name, conf.Peers, remove); err != nil {
+		log.Warnf("unable to update the peers in place, recreating the link: %s", err.Error())
+		return i.configure(workingPeers)
+	}
+	i.setApplied(workingPeers, false)
+	return nil
+}
+
+// remotePeers returns peers without the local one
+func (i *Interface) remotePeers(peers []Peer) []Peer {
+	remote := []Peer{}
+	for _, p := range peers {
+		if !bytes.Equal(p.PublicKey, i.LocalPeer.PublicKey) {
+			remote = append(remote, p)
+		}
+	}
+	return remote
+}
+
+// setApplied records the peers applied to the link and reports what
+// changed. linkCreated tells if the link was brought up again.
+func (i *Interface) setApplied(workingPeers []Peer, linkCreated bool) {
+	applied := i.remotePeers(workingPeers)
+	i.mu.Lock()
+	diff := DiffPeers(i.applied, applied)
+	initial := !i.configured
+	i.applied = applied
+	i.linkUp = true
+	i.configured = true
+	i.mu.Unlock()
+
+	logDiff(diff)
+	i.recorder().PeersApplied(len(applied), diff)
+	if linkCreated {
+		i.notify(Event{Type: EventPostUp, Peers: applied, Diff: diff, Initial: initial})
+	}
+	for _, e := range peerEvents(diff, applied, initial) {
+		i.notify(e)
+	}
+}
+
+// stop tells the observers that wirey does not manage the link anymore,
+// the link itself is left up
+func (i *Interface) stop() {
+	s := i.Status()
+	if s.LinkUp {
+		i.notify(Event{Type: EventPreDown, Peers: s.Applied})
+	}
+}
+
+func (i *Interface) recorder() Recorder {
+	if i.Recorder == nil {
+		return nopRecorder{}
+	}
+	return i.Recorder
+}
+
+// newBackOff returns a jittered exponential backoff that stops when the
+// context is done, after maxElapsedTime or, if maxRetries is not zero,
+// after maxRetries attempts. A zero maxElapsedTime never stops.
+func newBackOff(ctx context.Context, maxRetries uint64, maxElapsedTime time.Duration) backoff.BackOff {
+	exp := backoff.NewExponentialBackOff()
+	exp.MaxElapsedTime = maxElapsedTime
+	exp.MaxInterval = MaxInterval
+	exp.InitialInterval = time.Duration(rand.Intn(JitterRange)+1) * time.Second
+
+	b := backoff.WithContext(exp, ctx)
+	if maxRetries == 0 {
+		return b
+	}
+	return backoff.WithMaxRetries(b, maxRetries)
+}
+
+// notifyRetry logs the failure, the loop is still alive until the next attempt
+func (i *Interface) notifyRetry(err error, next time.Duration) {
+	log.Warnf("wirey error %+v, retrying in %s\n", err, next)
+	i.tick(next)
+}
+
+// tick records that the loop is alive and expects to run again within next
+func (i *Interface) tick(next time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.lastActivity = time.Now()
+	i.nextActivity = next
 }
 
 func validatePort(port string) error {
@@ -0,0 +1,527 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"wirey/pkg/wireguard"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingDataplane is a fake dataplane.Dataplane that records the calls
+// made by the plumber instead of touching the kernel.
+type recordingDataplane struct {
+	mutex sync.Mutex
+	calls []string
+	confs []wireguard.Configuration
+}
+
+func (r *recordingDataplane) record(call string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *recordingDataplane) LinkAdd(name string) error {
+	r.record("LinkAdd " + name)
+	return nil
+}
+
+func (r *recordingDataplane) AddrAdd(name string, cidr string) error {
+	r.record("AddrAdd " + name + " " + cidr)
+	return nil
+}
+
+func (r *recordingDataplane) SetConf(name string, conf wireguard.Configuration) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls = append(r.calls, "SetConf "+name)
+	r.confs = append(r.confs, conf)
+	return nil
+}
+
+func (r *recordingDataplane) LinkUp(name string) error {
+	r.record("LinkUp " + name)
+	return nil
+}
+
+// lastConf returns the last configuration applied, if any
+func (r *recordingDataplane) lastConf() (wireguard.Configuration, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.confs) == 0 {
+		return wireguard.Configuration{}, false
+	}
+	return r.confs[len(r.confs)-1], true
+}
+
+func (r *recordingDataplane) Calls() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.calls...)
+}
+
+func testPeer(n int) Peer {
+	ip := net.IPv4(10, 0, 0, byte(n))
+	return Peer{
+		PublicKey: []byte(fmt.Sprintf("peer-%d-public-key\n", n)),
+		Endpoint:  fmt.Sprintf("192.168.0.%d:2345", n),
+		IP:        &ip,
+	}
+}
+
+func testInterface(b Backend, d *recordingDataplane) *Interface {
+	return &Interface{
+		Backend:      b,
+		Name:         "wg0",
+		PeerCheckTTL: 10 * time.Millisecond,
+		LocalPeer:    testPeer(1),
+		Dataplane:    d,
+		privateKey:   []byte("local-private-key"),
+	}
+}
+
+// connect runs Connect in the background, the returned function stops it
+// and returns its error.
+func connect(t *testing.T, i *Interface) func() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- i.Connect(ctx)
+	}()
+
+	return func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("Connect did not return after cancellation")
+			return nil
+		}
+	}
+}
+
+func peerKeys(conf wireguard.Configuration) []string {
+	keys := []string{}
+	for _, p := range conf.Peers {
+		keys = append(keys, p.PublicKey)
+	}
+	return keys
+}
+
+func waitForPeers(t *testing.T, d *recordingDataplane, expected ...Peer) wireguard.Configuration {
+	t.Helper()
+	keys := []string{}
+	for _, p := range expected {
+		keys = append(keys, string(p.PublicKey))
+	}
+
+	var conf wireguard.Configuration
+	require.Eventually(t, func() bool {
+		var ok bool
+		conf, ok = d.lastConf()
+		return ok && len(keys) == len(conf.Peers) && subset(keys, peerKeys(conf))
+	}, 5*time.Second, 5*time.Millisecond, "the dataplane never received peers %v", keys)
+	return conf
+}
+
+func subset(expected, actual []string) bool {
+	for _, e := range expected {
+		found := false
+		for _, a := range actual {
+			if a == e {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestConnectAppliesBackendPeers(t *testing.T) {
+	b := NewMemoryBackend()
+	d := &recordingDataplane{}
+	peer := testPeer(2)
+	peer.AllowedIPs = []string{"10.10.0.0/16"}
+	require.NoError(t, b.Join(context.Background(), "wg0", peer))
+
+	i := testInterface(b, d)
+	stop := connect(t, i)
+
+	conf := waitForPeers(t, d, peer)
+	assert.Equal(t, 2345, conf.Interface.ListenPort)
+	assert.Equal(t, "local-private-key", conf.Interface.PrivateKey)
+	assert.Equal(t, wireguard.Peer{
+		PublicKey:  string(peer.PublicKey),
+		AllowedIPs: "10.0.0.2/32,10.10.0.0/16",
+		Endpoint:   "192.168.0.2:2345",
+	}, conf.Peers[0])
+
+	assert.Equal(t, []string{
+		"LinkAdd wg0",
+		"SetConf wg0",
+		"AddrAdd wg0 10.0.0.1/24",
+		"LinkUp wg0",
+	}, d.Calls()[:4])
+
+	assert.NoError(t, stop())
+	state, err := i.State()
+	assert.Equal(t, StateApplied, state)
+	assert.NoError(t, err)
+}
+
+func TestConnectJoinsTheLocalPeer(t *testing.T) {
+	b := NewMemoryBackend()
+	d := &recordingDataplane{}
+	i := testInterface(b, d)
+	stop := connect(t, i)
+
+	require.Eventually(t, func() bool {
+		peers, _ := b.GetPeers(context.Background(), "wg0")
+		return len(peers) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// the local peer is never configured as a peer of itself
+	waitForPeers(t, d)
+	assert.NoError(t, stop())
+}
+
+func TestConnectRegistersThePeerChangedByOnJoin(t *testing.T) {
+	b := NewMemoryBackend()
+	i := testInterface(b, &recordingDataplane{})
+	i.OnJoin = func(ctx context.Context, i *Interface) error {
+		i.LocalPeer.Endpoint = "192.168.0.1:51820"
+		return nil
+	}
+	stop := connect(t, i)
+
+	require.Eventually(t, func() bool {
+		peers, _ := b.GetPeers(context.Background(), "wg0")
+		return len(peers) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, stop())
+
+	// the first record is the one changed by OnJoin
+	peers, err := b.GetPeers(context.Background(), "wg0")
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.0.1:51820", peers[0].Endpoint)
+}
+
+func TestConnectReconfiguresWhenPeersChange(t *testing.T) {
+	b := NewMemoryBackend()
+	d := &recordingDataplane{}
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(2)))
+
+	stop := connect(t, testInterface(b, d))
+	waitForPeers(t, d, testPeer(2))
+
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(3)))
+	waitForPeers(t, d, testPeer(2), testPeer(3))
+
+	changed := testPeer(3)
+	changed.Endpoint = "192.168.1.3:2345"
+	require.NoError(t, b.Join(context.Background(), "wg0", changed))
+	require.Eventually(t, func() bool {
+		conf, _ := d.lastConf()
+		for _, p := range conf.Peers {
+			if p.Endpoint == changed.Endpoint {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, stop())
+}
+
+func TestConnectDoesNotReconfigureUnchangedPeers(t *testing.T) {
+	b := NewMemoryBackend()
+	d := &recordingDataplane{}
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(2)))
+
+	stop := connect(t, testInterface(b, d))
+	waitForPeers(t, d, testPeer(2))
+
+	// let a few checks go by
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, stop())
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	assert.Len(t, d.confs, 1)
+}
+
+func TestConnectMigratesToTheNetwork(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	d := &recordingDataplane{}
+	require.NoError(t, b.Join(ctx, "wg0", testPeer(2)))
+	require.NoError(t, b.PutSettings(ctx, "wg0", Settings{MTU: 1420}))
+
+	i := testInterface(b, d)
+	i.Network = "mesh"
+	stop := connect(t, i)
+	waitForPeers(t, d, testPeer(2))
+	require.NoError(t, stop())
+
+	peers, err := b.GetPeers(ctx, "mesh")
+	require.NoError(t, err)
+	assert.Len(t, peers, 2)
+	settings, err := b.GetSettings(ctx, "mesh")
+	require.NoError(t, err)
+	assert.Equal(t, &Settings{MTU: 1420}, settings)
+
+	// the old records stay, a network that is not empty is not migrated again
+	peers, err = b.GetPeers(ctx, "wg0")
+	require.NoError(t, err)
+	assert.Len(t, peers, 1)
+	require.NoError(t, b.Leave(ctx, "mesh", testPeer(2).PublicKey))
+	require.NoError(t, i.migrate(ctx))
+	peers, err = b.GetPeers(ctx, "mesh")
+	require.NoError(t, err)
+	assert.Len(t, peers, 1)
+}
+
+// legacyBackend is a MemoryBackend with peers still stored under legacy keys
+type legacyBackend struct {
+	*MemoryBackend
+	legacy map[string][]Peer
+}
+
+func (b *legacyBackend) MigrateKeys(ctx context.Context, network string, opts MigrateOptions) ([]string, error) {
+	keys := []string{}
+	for _, p := range b.legacy[network] {
+		keys = append(keys, peerKey(p))
+		if opts.DryRun {
+			continue
+		}
+		if err := b.Join(ctx, network, p); err != nil {
+			return keys, err
+		}
+	}
+	if !opts.DryRun && !opts.Keep {
+		delete(b.legacy, network)
+	}
+	return keys, nil
+}
+
+// operationRecorder records the backend operations
+type operationRecorder struct {
+	nopRecorder
+	mu         sync.Mutex
+	operations []string
+}
+
+func (r *operationRecorder) BackendRequest(operation string, duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.operations = append(r.operations, operation)
+}
+
+func TestConnectCopiesTheLegacyKeys(t *testing.T) {
+	b := &legacyBackend{
+		MemoryBackend: NewMemoryBackend(),
+		legacy:        map[string][]Peer{"wg0": {testPeer(2)}},
+	}
+	d := &recordingDataplane{}
+	r := &operationRecorder{}
+
+	i := testInterface(InstrumentBackend(b, r), d)
+	stop := connect(t, i)
+	waitForPeers(t, d, testPeer(2))
+	require.NoError(t, stop())
+
+	// the legacy records stay for the nodes not upgraded yet
+	assert.Len(t, b.legacy["wg0"], 1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	assert.Contains(t, r.operations, OperationMigrateKeys, "the migration goes through the recorder")
+}
+
+func TestCheckSplitLogsThePeersLeftBehind(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	require.NoError(t, b.Join(ctx, "mesh", testPeer(1)))
+	require.NoError(t, b.Join(ctx, "mesh", testPeer(2)))
+	require.NoError(t, b.Join(ctx, "wg0", testPeer(2)))
+	require.NoError(t, b.Join(ctx, "wg0", testPeer(3)))
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	i := testInterface(b, &recordingDataplane{})
+	i.Network = "mesh"
+	network := func() []Peer {
+		peers, err := b.GetPeers(ctx, "mesh")
+		require.NoError(t, err)
+		return peers
+	}
+
+	i.checkSplit(ctx, network())
+	require.Len(t, hook.Entries, 1)
+	assert.Equal(t, log.WarnLevel, hook.LastEntry().Level)
+	assert.Contains(t, hook.LastEntry().Message, peerKey(testPeer(3)))
+
+	i.checkSplit(ctx, network())
+	assert.Len(t, hook.Entries, 1, "an unchanged split is logged once")
+
+	require.NoError(t, b.Join(ctx, "mesh", testPeer(3)))
+	i.checkSplit(ctx, network())
+	require.Len(t, hook.Entries, 2)
+	assert.Equal(t, log.InfoLevel, hook.LastEntry().Level, "the old records can be removed")
+}
+
+func TestConnectAddressAlreadyTaken(t *testing.T) {
+	b := NewMemoryBackend()
+	d := &recordingDataplane{}
+	other := testPeer(2)
+	other.IP = testPeer(1).IP
+	require.NoError(t, b.Join(context.Background(), "wg0", other))
+
+	err := testInterface(b, d).Connect(context.Background())
+	assert.Error(t, err)
+	assert.Empty(t, d.Calls())
+}
+
+func TestConnectRestoresCachedPeers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wirey-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache := NewPeerCache(filepath.Join(dir, "peers-wg0.json"), "wg0")
+	require.NoError(t, cache.Store([]Peer{testPeer(1), testPeer(2)}))
+
+	// the backend never answers, the cached peers must be applied anyway
+	d := &recordingDataplane{}
+	i := testInterface(unreachableBackend{}, d)
+	i.PeerCache = cache
+	stop := connect(t, i)
+
+	waitForPeers(t, d, testPeer(2))
+	state, err := i.State()
+	assert.Equal(t, StateDegraded, state)
+	assert.Error(t, err)
+	assert.NoError(t, stop())
+}
+
+func TestRestoreIgnoresTheCacheOfAnotherNetwork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wirey-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "peers-wg0.json")
+	require.NoError(t, NewPeerCache(path, "mesh").Store([]Peer{testPeer(2)}))
+	legacy := filepath.Join(dir, "peers.json")
+	require.NoError(t, ioutil.WriteFile(legacy, []byte(`[{"PublicKey":"cGVlcg=="}]`), 0600))
+
+	for _, cache := range []*PeerCache{NewPeerCache(path, "wg0"), NewPeerCache(legacy, "wg0")} {
+		d := &recordingDataplane{}
+		i := testInterface(unreachableBackend{}, d)
+		i.PeerCache = cache
+		assert.Nil(t, i.restore())
+		assert.Empty(t, d.Calls(), "the cached peers of another network are not restored")
+	}
+}
+
+type unreachableBackend struct{}
+
+func (unreachableBackend) Join(ctx context.Context, ifname string, p Peer) error {
+	return fmt.Errorf("backend unreachable")
+}
+
+func (unreachableBackend) Leave(ctx context.Context, ifname string, publicKey []byte) error {
+	return fmt.Errorf("backend unreachable")
+}
+
+func (unreachableBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	return nil, fmt.Errorf("backend unreachable")
+}
+
+func TestConnectCommands(t *testing.T) {
+	b := NewMemoryBackend()
+	d := &recordingDataplane{}
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(2)))
+
+	i := testInterface(b, d)
+	i.PeerCheckTTL = time.Hour
+	stop := connect(t, i)
+	waitForPeers(t, d, testPeer(2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// resync applies the peers again even if they did not change
+	require.NoError(t, i.Resync(ctx))
+	d.mutex.Lock()
+	assert.Len(t, d.confs, 2)
+	d.mutex.Unlock()
+
+	require.NoError(t, i.Leave(ctx))
+	peers, _ := b.GetPeers(ctx, "wg0")
+	assert.Len(t, peers, 1)
+	assert.False(t, i.Status().Joined)
+
+	require.NoError(t, i.Rejoin(ctx))
+	peers, _ = b.GetPeers(ctx, "wg0")
+	assert.Len(t, peers, 2)
+
+	// without OnReload there is nothing to reload
+	assert.Error(t, i.Reload(ctx))
+	assert.Error(t, i.Command(ctx, "explode"))
+
+	status := i.Status()
+	assert.True(t, status.Joined)
+	assert.Equal(t, StateApplied, status.State)
+	assert.NoError(t, status.BackendError)
+	assert.False(t, status.LastSync.IsZero())
+	// the local peer is not part of the applied peers
+	if assert.Len(t, status.Applied, 1) {
+		assert.Equal(t, string(testPeer(2).PublicKey), string(status.Applied[0].PublicKey))
+	}
+
+	assert.NoError(t, stop())
+}
+
+func TestReloadDoesNotRejoinAfterLeave(t *testing.T) {
+	b := NewMemoryBackend()
+	i := testInterface(b, &recordingDataplane{})
+	i.PeerCheckTTL = time.Hour
+	reloads := 0
+	i.OnReload = func(i *Interface) error {
+		reloads++
+		return nil
+	}
+	stop := connect(t, i)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, i.Resync(ctx))
+	require.NoError(t, i.Leave(ctx))
+
+	require.NoError(t, i.Reload(ctx))
+	assert.Equal(t, 1, reloads)
+	peers, _ := b.GetPeers(ctx, "wg0")
+	assert.Empty(t, peers, "a reload does not undo a leave")
+	assert.False(t, i.Status().Joined)
+
+	require.NoError(t, i.Rejoin(ctx))
+	require.NoError(t, i.Reload(ctx))
+	peers, _ = b.GetPeers(ctx, "wg0")
+	assert.Len(t, peers, 1)
+	assert.True(t, i.Status().Joined)
+
+	assert.NoError(t, stop())
+}
@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"context"
+	"time"
+)
+
+// Backend operations, as reported to a Recorder
+const (
+	OperationJoin        = "Join"
+	OperationGetPeers    = "GetPeers"
+	OperationLeave       = "Leave"
+	OperationGetSettings = "GetSettings"
+	OperationPutSettings = "PutSettings"
+	OperationMigrateKeys = "MigrateKeys"
+)
+
+// Recorder receives the measurements of an Interface, e.g. to export them
+// as metrics. It is called from the Connect loop and must not block.
+type Recorder interface {
+	// BackendRequest is called after every call to the backend
+	BackendRequest(operation string, duration time.Duration, err error)
+	// Reconcile is called after every reconcile
+	Reconcile(duration time.Duration, err error)
+	// PeersApplied is called every time peers are applied to the device,
+	// diff is what changed since the previous ones
+	PeersApplied(count int, diff PeerDiff)
+	// LinkCreated is called every time the link is (re)created
+	LinkCreated()
+}
+
+type nopRecorder struct{}
+
+func (nopRecorder) BackendRequest(operation string, duration time.Duration, err error) {}
+func (nopRecorder) Reconcile(duration time.Duration, err error)                        {}
+func (nopRecorder) PeersApplied(count int, diff PeerDiff)                              {}
+func (nopRecorder) LinkCreated()                                                       {}
+
+// instrumentedBackend reports the calls made to a Backend to a Recorder
+type instrumentedBackend struct {
+	backend  Backend
+	recorder Recorder
+}
+
+// InstrumentBackend wraps b so that every call is reported to r, the
+// wrapper is a SettingsStore when b is one. AsKeyMigrator reaches the
+// KeyMigrator behind it.
+func InstrumentBackend(b Backend, r Recorder) Backend {
+	ib := &instrumentedBackend{
+		backend:  b,
+		recorder: r,
+	}
+	if store, ok := b.(SettingsStore); ok {
+		return &instrumentedSettingsStore{instrumentedBackend: ib, store: store}
+	}
+	return ib
+}
+
+// Join ...
+func (ib *instrumentedBackend) Join(ctx context.Context, ifname string, peer Peer) error {
+	start := time.Now()
+	err := ib.backend.Join(ctx, ifname, peer)
+	ib.recorder.BackendRequest(OperationJoin, time.Since(start), err)
+	return err
+}
+
+// GetPeers ...
+func (ib *instrumentedBackend) GetPeers(ctx context.Context, ifname string) ([]Peer, error) {
+	start := time.Now()
+	peers, err := ib.backend.GetPeers(ctx, ifname)
+	ib.recorder.BackendRequest(OperationGetPeers, time.Since(start), err)
+	return peers, err
+}
+
+// Leave ...
+func (ib *instrumentedBackend) Leave(ctx context.Context, ifname string, publicKey []byte) error {
+	start := time.Now()
+	err := ib.backend.Leave(ctx, ifname, publicKey)
+	ib.recorder.BackendRequest(OperationLeave, time.Since(start), err)
+	return err
+}
@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"wirey/pkg/utils"
+)
+
+// KeySchemaVersion is the version of the layout of the records, every
+// backend stores the records of a network under the same paths:
+//
+//	v1/{network}/peers/{sha256 of the public key}
+//	v1/{network}/_config
+//
+// etcd and consul prefix them with their Prefix, the http backend appends
+// them, after its Prefix, to its base url.
+const KeySchemaVersion = "v1"
+
+// DefaultKeyPrefix is the Prefix of the etcd and consul backends, the http
+// backend has none by default
+const DefaultKeyPrefix = "wirey"
+
+// settingsName is the last element of the path of the Settings
+const settingsName = "_config"
+
+// PeersPath returns the path under which the peers of network are stored
+func PeersPath(network string) string {
+	return fmt.Sprintf("%s/%s/peers", KeySchemaVersion, network)
+}
+
+// PeerPath returns the path of the peer with the given public key
+func PeerPath(network string, publicKey []byte) string {
+	return fmt.Sprintf("%s/%s", PeersPath(network), utils.PublicKeySHA256(publicKey))
+}
+
+// SettingsPath returns the path of the Settings of network
+func SettingsPath(network string) string {
+	return fmt.Sprintf("%s/%s/%s", KeySchemaVersion, network, settingsName)
+}
+
+// isSchemaPath tells whether path, relative to the prefix, has the shape of
+// a record of the key schema, whatever its network. The legacy records of a
+// network named like the schema version share its root and are told apart
+// by their shape only.
+func isSchemaPath(path string) bool {
+	parts := strings.Split(path, "/")
+	if parts[0] != KeySchemaVersion {
+		return false
+	}
+	switch len(parts) {
+	case 3:
+		return parts[2] == settingsName
+	case 4:
+		return parts[2] == "peers" && isSHA256(parts[3])
+	}
+	return false
+}
+
+// isSHA256 tells whether s is a hex encoded sha256, as in a peer path
+func isSHA256(s string) bool {
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// prefixed joins prefix, without its leading and trailing slashes, and path.
+// A prefix like team-a/staging isolates the records of several clusters
+// sharing a backend.
+func prefixed(prefix, path string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return path
+	}
+	return fmt.Sprintf("%s/%s", prefix, path)
+}
+
+// MigrateOptions changes how MigrateKeys handles the legacy records
+type MigrateOptions struct {
+	// DryRun only lists the legacy keys, nothing changes
+	DryRun bool
+	// Keep copies the records and leaves the legacy ones in place for the
+	// nodes not upgraded yet
+	Keep bool
+}
+
+// KeyMigrator is implemented by the backends whose records used another
+// layout before the key schema
+type KeyMigrator interface {
+	// MigrateKeys moves the records of network from the legacy layout to
+	// the key schema, a record already in the schema is kept and the legacy
+	// one dropped. It returns the legacy keys.
+	MigrateKeys(ctx context.Context, network string, opts MigrateOptions) ([]string, error)
+}
+
+// AsKeyMigrator returns the KeyMigrator of b. When b is instrumented, the
+// calls are reported to its Recorder.
+func AsKeyMigrator(b Backend) (KeyMigrator, bool) {
+	var ib *instrumentedBackend
+	switch w := b.(type) {
+	case *instrumentedBackend:
+		ib = w
+	case *instrumentedSettingsStore:
+		ib = w.instrumentedBackend
+	default:
+		m, ok := b.(KeyMigrator)
+		return m, ok
+	}
+	m, ok := ib.backend.(KeyMigrator)
+	if !ok {
+		return nil, false
+	}
+	return &instrumentedKeyMigrator{migrator: m, recorder: ib.recorder}, true
+}
+
+// instrumentedKeyMigrator reports the calls made to a KeyMigrator to a
+// Recorder
+type instrumentedKeyMigrator struct {
+	migrator KeyMigrator
+	recorder Recorder
+}
+
+// MigrateKeys ...
+func (im *instrumentedKeyMigrator) MigrateKeys(ctx context.Context, network string, opts MigrateOptions) ([]string, error) {
+	start := time.Now()
+	keys, err := im.migrator.MigrateKeys(ctx, network, opts)
+	im.recorder.BackendRequest(OperationMigrateKeys, time.Since(start), err)
+	return keys, err
+}
@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// the smallest mtu allowed by ipv4 and the largest one of a link
+const (
+	minMTU = 576
+	maxMTU = 65535
+)
+
+const (
+	errSettingsPort       = "the endpoint-port %d is not a valid port"
+	errSettingsMTU        = "the mtu %d is not valid, it must be between %d and %d"
+	errSettingsTTL        = "the peerdiscoveryttl %q is not a valid duration: %s"
+	errSettingsAllowedIPs = "the allowed ip %q is not valid: %s"
+)
+
+// Settings is the configuration every node of a network has to agree on,
+// stored once in the backend instead of being repeated on every node. The
+// json names are the ones of the daemon flags, an empty field leaves the
+// local value in place.
+type Settings struct {
+	EndpointPort     int      `json:"endpoint-port,omitempty"`
+	MTU              int      `json:"mtu,omitempty"`
+	AllowedIPs       []string `json:"allowedips,omitempty"`
+	PeerDiscoveryTTL string   `json:"peerdiscoveryttl,omitempty"`
+}
+
+// Validate checks every field that is set
+func (s Settings) Validate() error {
+	if s.EndpointPort < 0 || s.EndpointPort > 65535 {
+		return fmt.Errorf(errSettingsPort, s.EndpointPort)
+	}
+	if s.MTU != 0 && (s.MTU < minMTU || s.MTU > maxMTU) {
+		return fmt.Errorf(errSettingsMTU, s.MTU, minMTU, maxMTU)
+	}
+	if s.PeerDiscoveryTTL != "" {
+		if ttl, err := time.ParseDuration(s.PeerDiscoveryTTL); err != nil {
+			return fmt.Errorf(errSettingsTTL, s.PeerDiscoveryTTL, err.Error())
+		} else if ttl <= 0 {
+			return fmt.Errorf(errSettingsTTL, s.PeerDiscoveryTTL, "it must be positive")
+		}
+	}
+	for _, cidr := range s.AllowedIPs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf(errSettingsAllowedIPs, cidr, err.Error())
+		}
+	}
+	return nil
+}
+
+// SettingsStore is implemented by the backends that can store the Settings
+// of a network
+type SettingsStore interface {
+	// GetSettings returns nil, and no error, when the network has no settings
+	GetSettings(ctx context.Context, ifname string) (*Settings, error)
+	// PutSettings replaces the settings of the network
+	PutSettings(ctx context.Context, ifname string, s Settings) error
+}
+
+// instrumentedSettingsStore is the instrumentedBackend of a SettingsStore
+type instrumentedSettingsStore struct {
+	*instrumentedBackend
+	store SettingsStore
+}
+
+// GetSettings ...
+func (is *instrumentedSettingsStore) GetSettings(ctx context.Context, ifname string) (*Settings, error) {
+	start := time.Now()
+	s, err := is.store.GetSettings(ctx, ifname)
+	is.recorder.BackendRequest(OperationGetSettings, time.Since(start), err)
+	return s, err
+}
+
+// PutSettings ...
+func (is *instrumentedSettingsStore) PutSettings(ctx context.Context, ifname string, s Settings) error {
+	start := time.Now()
+	err := is.store.PutSettings(ctx, ifname, s)
+	is.recorder.BackendRequest(OperationPutSettings, time.Since(start), err)
+	return err
+}
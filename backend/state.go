@@ -0,0 +1,29 @@
+package backend
+
+// State describes where an Interface is in its reconcile loop
+type State int
+
+const (
+	// StateJoining means the local peer is being registered in the backend
+	StateJoining State = iota
+	// StateSyncing means a new peer list is being applied to the interface
+	StateSyncing
+	// StateApplied means the interface matches the last peer list fetched from the backend
+	StateApplied
+	// StateDegraded means the last reconcile failed, the interface keeps its previous configuration
+	StateDegraded
+)
+
+func (s State) String() string {
+	switch s {
+	case StateJoining:
+		return "joining"
+	case StateSyncing:
+		return "syncing"
+	case StateApplied:
+		return "applied"
+	case StateDegraded:
+		return "degraded"
+	}
+	return "unknown"
+}
@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"wirey/pkg/wireguard"
+)
+
+// PeerHealth tells if the tunnel with a peer works
+type PeerHealth string
+
+// Peer health values
+const (
+	PeerHealthy     PeerHealth = "healthy"
+	PeerStale       PeerHealth = "stale"
+	PeerNoHandshake PeerHealth = "no-handshake"
+	// PeerBackendOnly peers are in the backend but not configured on the device
+	PeerBackendOnly PeerHealth = "backend-only"
+	// PeerDeviceOnly peers are configured on the device but not in the backend
+	PeerDeviceOnly PeerHealth = "device-only"
+)
+
+// PeerStatus merges the backend record of a peer with the state of the device
+type PeerStatus struct {
+	PublicKey       string     `json:"publicKey"`
+	Name            string     `json:"name,omitempty"`
+	IP              string     `json:"ip,omitempty"`
+	Endpoint        string     `json:"endpoint"`
+	AllowedIPs      []string   `json:"allowedIPs"`
+	Static          bool       `json:"static"`
+	LatestHandshake *time.Time `json:"latestHandshake,omitempty"`
+	TransferRx      int64      `json:"transferRx"`
+	TransferTx      int64      `json:"transferTx"`
+	Health          PeerHealth `json:"health"`
+}
+
+// Healthy is false when the peer needs attention. A peer that never completed
+// a handshake is not one: wireguard only starts one when there is traffic.
+// Static peers, like phones, come and go: a stale handshake is expected for
+// them.
+func (s PeerStatus) Healthy() bool {
+	switch s.Health {
+	case PeerHealthy, PeerNoHandshake:
+		return true
+	case PeerStale:
+		return s.Static
+	}
+	return false
+}
+
+// PeerStatuses compares the peers in the backend with the ones configured on
+// device, nil if it does not exist. A peer is stale when its latest handshake
+// is older than staleAfter. The local peer, the one with the device public
+// key, is left out.
+func PeerStatuses(peers []Peer, device *wireguard.Device, staleAfter time.Duration, now time.Time) []PeerStatus {
+	devicePeers := map[string]wireguard.DevicePeer{}
+	localKey := ""
+	if device != nil {
+		localKey = device.PublicKey
+		for _, dp := range device.Peers {
+			devicePeers[dp.PublicKey] = dp
+		}
+	}
+
+	statuses := []PeerStatus{}
+	seen := map[string]bool{}
+	for _, p := range peers {
+		key := strings.TrimSpace(string(p.PublicKey))
+		if key == localKey {
+			continue
+		}
+		seen[key] = true
+
+		s := PeerStatus{
+			PublicKey:  key,
+			Name:       p.Name,
+			Endpoint:   p.Endpoint,
+			AllowedIPs: p.AllowedIPs,
+			Static:     p.Static,
+			Health:     PeerBackendOnly,
+		}
+		if p.IP != nil {
+			s.IP = p.IP.String()
+		}
+		if dp, ok := devicePeers[key]; ok {
+			s.setDevicePeer(dp, staleAfter, now)
+		}
+		if s.AllowedIPs == nil {
+			s.AllowedIPs = []string{}
+		}
+		statuses = append(statuses, s)
+	}
+
+	for key, dp := range devicePeers {
+		if seen[key] {
+			continue
+		}
+		s := PeerStatus{PublicKey: key}
+		s.setDevicePeer(dp, staleAfter, now)
+		s.AllowedIPs = dp.AllowedIPs
+		s.Health = PeerDeviceOnly
+		statuses = append(statuses, s)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].PublicKey < statuses[j].PublicKey })
+	return statuses
+}
+
+func (s *PeerStatus) setDevicePeer(dp wireguard.DevicePeer, staleAfter time.Duration, now time.Time) {
+	// the endpoint the device is using, it changes when the peer roams
+	if dp.Endpoint != "" {
+		s.Endpoint = dp.Endpoint
+	}
+	s.TransferRx = dp.TransferRx
+	s.TransferTx = dp.TransferTx
+
+	switch {
+	case dp.LatestHandshake.IsZero():
+		s.Health = PeerNoHandshake
+	case now.Sub(dp.LatestHandshake) > staleAfter:
+		s.Health = PeerStale
+	default:
+		s.Health = PeerHealthy
+	}
+	if !dp.LatestHandshake.IsZero() {
+		handshake := dp.LatestHandshake
+		s.LatestHandshake = &handshake
+	}
+}
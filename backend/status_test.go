@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"wirey/pkg/wireguard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerStatuses(t *testing.T) {
+	now := time.Unix(1571234567, 0)
+	recent := now.Add(-time.Minute)
+	old := now.Add(-time.Hour)
+
+	static := testPeer(5)
+	static.Static = true
+	staleStatic := testPeer(7)
+	staleStatic.Static = true
+	peers := []Peer{testPeer(1), testPeer(2), testPeer(3), testPeer(4), static, testPeer(6), staleStatic}
+
+	device := &wireguard.Device{
+		PublicKey: "peer-1-public-key",
+		Peers: []wireguard.DevicePeer{
+			{PublicKey: "peer-2-public-key", Endpoint: "192.168.5.2:2345", LatestHandshake: recent, TransferRx: 10, TransferTx: 20},
+			{PublicKey: "peer-3-public-key", LatestHandshake: old},
+			{PublicKey: "peer-5-public-key"},
+			{PublicKey: "peer-6-public-key"},
+			{PublicKey: "peer-7-public-key", LatestHandshake: old},
+			{PublicKey: "peer-9-public-key", AllowedIPs: []string{"10.0.0.9/32"}},
+		},
+	}
+
+	statuses := PeerStatuses(peers, device, 5*time.Minute, now)
+	require.Len(t, statuses, 7, "the local peer is not reported")
+
+	health := map[string]PeerHealth{}
+	healthy := map[string]bool{}
+	for _, s := range statuses {
+		health[s.PublicKey] = s.Health
+		healthy[s.PublicKey] = s.Healthy()
+	}
+	assert.Equal(t, map[string]PeerHealth{
+		"peer-2-public-key": PeerHealthy,
+		"peer-3-public-key": PeerStale,
+		"peer-4-public-key": PeerBackendOnly,
+		"peer-5-public-key": PeerNoHandshake,
+		"peer-6-public-key": PeerNoHandshake,
+		"peer-7-public-key": PeerStale,
+		"peer-9-public-key": PeerDeviceOnly,
+	}, health)
+	assert.Equal(t, map[string]bool{
+		"peer-2-public-key": true,
+		"peer-3-public-key": false,
+		"peer-4-public-key": false,
+		"peer-5-public-key": true,
+		"peer-6-public-key": true,
+		"peer-7-public-key": true,
+		"peer-9-public-key": false,
+	}, healthy)
+
+	assert.Equal(t, PeerStatus{
+		PublicKey:       "peer-2-public-key",
+		IP:              "10.0.0.2",
+		Endpoint:        "192.168.5.2:2345",
+		AllowedIPs:      []string{},
+		LatestHandshake: &recent,
+		TransferRx:      10,
+		TransferTx:      20,
+		Health:          PeerHealthy,
+	}, statuses[0])
+}
+
+func TestPeerStatusesWithoutDevice(t *testing.T) {
+	statuses := PeerStatuses([]Peer{testPeer(1)}, nil, time.Minute, time.Now())
+	require.Len(t, statuses, 1)
+	assert.Equal(t, PeerBackendOnly, statuses[0].Health)
+	assert.False(t, statuses[0].Healthy())
+}
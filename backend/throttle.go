@@ -0,0 +1,160 @@
+package backend
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ApplyPolicy limits how often peer changes are applied, e.g. while many
+// nodes join at once. The zero value applies every change right away.
+type ApplyPolicy struct {
+	// Debounce is how long the peers must stay the same before a change is
+	// applied
+	Debounce time.Duration
+	// MaxDelay bounds the wait for the peers to settle, zero waits forever
+	MaxDelay time.Duration
+	// MinInterval is the minimum time between two applies
+	MinInterval time.Duration
+	// FlapThreshold is how many times a peer can appear or disappear within
+	// FlapWindow before it is damped: it is kept as applied until it is
+	// stable again. Zero disables the damping.
+	FlapThreshold int
+	FlapWindow    time.Duration
+}
+
+// throttle is the state of the ApplyPolicy
+type throttle struct {
+	// pending is the last peer set that could not be applied yet
+	pending      []Peer
+	pendingSince time.Time
+	lastChange   time.Time
+	lastApply    time.Time
+
+	// seen tells which peers were in the last list fetched
+	seen    map[string]bool
+	toggles map[string][]time.Time
+	damped  map[string]bool
+}
+
+// damp returns fetched where the flapping peers are replaced by their
+// applied record, or left out when they are not applied
+func (t *throttle) damp(policy ApplyPolicy, fetched, applied []Peer, now time.Time) []Peer {
+	if policy.FlapThreshold <= 0 {
+		return fetched
+	}
+	if t.toggles == nil {
+		t.toggles = map[string][]time.Time{}
+		t.damped = map[string]bool{}
+	}
+
+	present := map[string]bool{}
+	for _, p := range fetched {
+		present[string(p.PublicKey)] = true
+	}
+	if t.seen != nil {
+		for key := range union(present, t.seen) {
+			if present[key] != t.seen[key] {
+				t.toggles[key] = append(t.toggles[key], now)
+			}
+		}
+	}
+	t.seen = present
+
+	for key, toggles := range t.toggles {
+		recent := toggles[:0]
+		for _, at := range toggles {
+			if now.Sub(at) < policy.FlapWindow {
+				recent = append(recent, at)
+			}
+		}
+		if len(recent) == 0 {
+			delete(t.toggles, key)
+		} else {
+			t.toggles[key] = recent
+		}
+
+		damped := len(recent) >= policy.FlapThreshold
+		if damped != t.damped[key] {
+			if damped {
+				log.Warnf("Peer %s is flapping, holding its changes", peerKey(Peer{PublicKey: []byte(key)}))
+			} else {
+				log.Infof("Peer %s is stable again", peerKey(Peer{PublicKey: []byte(key)}))
+			}
+		}
+		if damped {
+			t.damped[key] = true
+		} else {
+			delete(t.damped, key)
+		}
+	}
+
+	if len(t.damped) == 0 {
+		return fetched
+	}
+	peers := []Peer{}
+	for _, p := range fetched {
+		if !t.damped[string(p.PublicKey)] {
+			peers = append(peers, p)
+		}
+	}
+	for _, p := range applied {
+		if t.damped[string(p.PublicKey)] {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// wait returns how long peers must wait before being applied, zero when
+// they can be applied now
+func (t *throttle) wait(policy ApplyPolicy, peers []Peer, now time.Time) time.Duration {
+	if t.pending == nil || !DiffPeers(t.pending, peers).Empty() {
+		t.lastChange = now
+		if t.pending == nil {
+			t.pendingSince = now
+		}
+		t.pending = peers
+	}
+
+	var wait time.Duration
+	if quiet := t.lastChange.Add(policy.Debounce).Sub(now); quiet > wait {
+		wait = quiet
+		if policy.MaxDelay > 0 {
+			if deadline := t.pendingSince.Add(policy.MaxDelay).Sub(now); deadline < wait {
+				wait = deadline
+			}
+		}
+	}
+	if next := t.lastApply.Add(policy.MinInterval).Sub(now); next > wait {
+		wait = next
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// applied resets the pending change
+func (t *throttle) applied(now time.Time) {
+	t.pending = nil
+	t.pendingSince = time.Time{}
+	t.lastApply = now
+}
+
+// clear drops the pending change, the peers went back to the applied ones
+func (t *throttle) clear() {
+	t.pending = nil
+	t.pendingSince = time.Time{}
+}
+
+func union(a, b map[string]bool) map[string]bool {
+	u := map[string]bool{}
+	for k := range a {
+		u[k] = true
+	}
+	for k := range b {
+		u[k] = true
+	}
+	return u
+}
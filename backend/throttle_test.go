@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottleWait(t *testing.T) {
+	policy := ApplyPolicy{Debounce: 10 * time.Second, MaxDelay: 25 * time.Second, MinInterval: time.Minute}
+	start := time.Now()
+	th := &throttle{}
+
+	assert.Equal(t, 10*time.Second, th.wait(policy, []Peer{testPeer(2)}, start))
+	assert.Equal(t, 5*time.Second, th.wait(policy, []Peer{testPeer(2)}, start.Add(5*time.Second)), "the peers did not change")
+	assert.Equal(t, 10*time.Second, th.wait(policy, []Peer{testPeer(2), testPeer(3)}, start.Add(8*time.Second)), "the peers changed")
+	assert.Equal(t, 7*time.Second, th.wait(policy, []Peer{testPeer(2), testPeer(4)}, start.Add(18*time.Second)), "bounded by the max delay")
+	assert.Equal(t, time.Duration(0), th.wait(policy, []Peer{testPeer(2), testPeer(4)}, start.Add(28*time.Second)))
+	th.applied(start.Add(28 * time.Second))
+
+	assert.Equal(t, 50*time.Second, th.wait(policy, []Peer{testPeer(2)}, start.Add(38*time.Second)), "the min interval is longer")
+
+	th = &throttle{}
+	assert.Equal(t, time.Duration(0), th.wait(ApplyPolicy{}, []Peer{testPeer(2)}, start), "the zero policy does not wait")
+}
+
+func TestThrottleDamp(t *testing.T) {
+	policy := ApplyPolicy{FlapThreshold: 3, FlapWindow: time.Minute}
+	start := time.Now()
+	th := &throttle{}
+	applied := []Peer{testPeer(2), testPeer(3)}
+
+	fetched := []Peer{testPeer(2), testPeer(3)}
+	assert.Equal(t, fetched, th.damp(policy, fetched, applied, start))
+	assert.Equal(t, []Peer{testPeer(2)}, th.damp(policy, []Peer{testPeer(2)}, applied, start.Add(time.Second)))
+	assert.Equal(t, fetched, th.damp(policy, fetched, applied, start.Add(2*time.Second)))
+
+	// the third toggle damps peer 3, it stays applied
+	assert.Equal(t, fetched, th.damp(policy, []Peer{testPeer(2)}, applied, start.Add(3*time.Second)))
+	assert.Equal(t, fetched, th.damp(policy, []Peer{testPeer(2)}, applied, start.Add(30*time.Second)))
+
+	// a damped peer that is not applied is not added
+	assert.Equal(t, []Peer{testPeer(2)}, th.damp(policy, fetched, []Peer{testPeer(2)}, start.Add(40*time.Second)))
+
+	// stable for a window
+	assert.Equal(t, fetched, th.damp(policy, fetched, []Peer{testPeer(2)}, start.Add(2*time.Minute)))
+
+	assert.Equal(t, []Peer{testPeer(2)}, th.damp(ApplyPolicy{}, []Peer{testPeer(2)}, applied, start), "no damping by default")
+}
+
+func TestConnectDebounces(t *testing.T) {
+	b := NewMemoryBackend()
+	d := &recordingDataplane{}
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(2)))
+
+	i := testInterface(b, d)
+	i.ApplyPolicy = ApplyPolicy{Debounce: 200 * time.Millisecond, MaxDelay: time.Second}
+	stop := connect(t, i)
+	waitForPeers(t, d, testPeer(2))
+
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(3)))
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(4)))
+	waitForPeers(t, d, testPeer(2), testPeer(3), testPeer(4))
+	require.NoError(t, stop())
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	assert.Len(t, d.confs, 2, "the two joins are applied at once")
+}
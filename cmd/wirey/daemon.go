@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"wirey/backend"
+	"wirey/pkg/control"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// daemonTimeout bounds a command, the daemon retries the backend before answering
+const daemonTimeout = 5 * time.Minute
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "query and control the running daemon through its control api",
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show the state of the daemon and the peers it applied",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), daemonTimeout)
+		defer cancel()
+		status, err := daemonClient().Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		format, _ := cmd.Flags().GetString("format")
+		if err := printDaemonStatus(os.Stdout, format, status); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func newDaemonCommand(name, short string) *cobra.Command {
+	return &cobra.Command{
+		Use:   name,
+		Short: short,
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			setLogLevel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), daemonTimeout)
+			defer cancel()
+			status, err := daemonClient().Command(ctx, name)
+			if err != nil {
+				log.Fatalf("%s failed: %s", name, err.Error())
+			}
+			if err := printDaemonStatus(os.Stdout, peersFormatTable, status); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+}
+
+// daemonClient returns the client of the control api, exiting when no
+// daemon is listening
+func daemonClient() *control.Client {
+	socket := controlSocket()
+	if socket == "" {
+		log.Fatal("the control api is disabled")
+	}
+	client := control.NewClient(socket)
+	if !client.Available() {
+		log.Fatalf("no daemon is listening on %s", socket)
+	}
+	return client
+}
+
+// runningDaemon returns the client of the control api when a daemon is
+// listening, nil otherwise
+func runningDaemon() *control.Client {
+	socket := controlSocket()
+	if socket == "" {
+		return nil
+	}
+	client := control.NewClient(socket)
+	if !client.Available() {
+		return nil
+	}
+	return client
+}
+
+// resyncDaemon asks the running daemon, if any, to apply a change made to
+// the backend now instead of at its next check
+func resyncDaemon() {
+	client := runningDaemon()
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), daemonTimeout)
+	defer cancel()
+	if _, err := client.Command(ctx, backend.CommandResync); err != nil {
+		log.Warnf("the change is in the backend but the daemon could not apply it: %s", err.Error())
+		return
+	}
+	fmt.Println("the running daemon applied the change")
+}
+
+func printDaemonStatus(w io.Writer, format string, status *control.Status) error {
+	switch format {
+	case peersFormatJSON:
+		return printJSON(w, status)
+	case peersFormatTable:
+		printDaemonSummary(w, status)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PUBLIC KEY\tNAME\tIP\tENDPOINT\tALLOWED IPS\tSTATIC")
+		for _, p := range status.Peers {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\n",
+				p.PublicKey, dash(p.Name), dash(p.IP), dash(p.Endpoint), dash(strings.Join(p.AllowedIPs, ",")), p.Static)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown format %q, available formats: [%s, %s]", format, peersFormatTable, peersFormatJSON)
+}
+
+func printDaemonSummary(w io.Writer, status *control.Status) {
+	lastSync := "never"
+	if status.LastSync != nil {
+		lastSync = fmt.Sprintf("%s ago", time.Since(*status.LastSync).Truncate(time.Second))
+	}
+	backendHealth := "healthy"
+	if !status.Backend.Healthy {
+		backendHealth = "unreachable: " + status.Backend.Error
+	}
+	fmt.Fprintf(w, "interface: %s\nstate: %s\njoined: %t\nlast sync: %s\nbackend: %s\n",
+		status.Interface, status.State, status.Joined, lastSync, backendHealth)
+	if status.Error != "" {
+		fmt.Fprintf(w, "error: %s\n", status.Error)
+	}
+	fmt.Fprintln(w)
+}
+
+func init() {
+	daemonStatusCmd.Flags().String("format", peersFormatTable, "the output format: table or json")
+
+	daemonCmd.AddCommand(daemonStatusCmd)
+	daemonCmd.AddCommand(newDaemonCommand(backend.CommandResync, "fetch the peers and apply them again, even if they did not change"))
+	daemonCmd.AddCommand(newDaemonCommand(backend.CommandRejoin, "register this node in the backend again"))
+	daemonCmd.AddCommand(newDaemonCommand(backend.CommandLeave, "remove this node from the backend, the tunnels with the other peers stay up"))
+	daemonCmd.AddCommand(newDaemonCommand(backend.CommandReload, "read the configuration file again and apply the local peer settings, the peer discovery ttl and the log level"))
+	rootCmd.AddCommand(daemonCmd)
+}
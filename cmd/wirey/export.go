@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"wirey/backend"
+	"wirey/pkg/wireguard"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export the wireguard configuration of this node for wg-quick, systemd-networkd or NetworkManager",
+	Long: `Fetch the peers from the configured backend and render the same configuration the daemon applies,
+including the interface address, in a format understood by another tool:
+
+  wg-quick    <ifname>.conf
+  networkd    <ifname>.netdev and <ifname>.network
+  nm-keyfile  <ifname>.nmconnection
+
+The files contain the private key of this node. Without --output they are printed on stdout,
+each one preceded by a "# <file name>" line. Nothing is written in the backend.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		b, err := backendFactory()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// export never generates a key, it must be the one the node joined with
+		privKey, err := ioutil.ReadFile(viper.GetString("privatekeypath"))
+		if err != nil {
+			log.Fatalf("unable to read the private key: %s", err.Error())
+		}
+
+		conf, ip, err := desiredConfiguration(b, privKey, cmd.Flags())
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+		dns, _ := cmd.Flags().GetStringSlice("dns")
+
+		files, err := wireguard.Export(format, conf, wireguard.ExportOptions{
+			Name:    viper.GetString("ifname"),
+			Address: backend.LinkAddress(ip),
+			DNS:     dns,
+			MTU:     settingInt("mtu"),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, f := range files {
+			if output == "" {
+				fmt.Printf("# %s\n%s\n", f.Name, f.Content)
+				continue
+			}
+			path := filepath.Join(output, f.Name)
+			if err := writeFile(path, f.Content, f.Mode, f.Group); err != nil {
+				log.Fatalf("unable to write %s: %s", path, err.Error())
+			}
+			log.Infof("Written %s", path)
+		}
+	},
+}
+
+// writeFile replaces path atomically, so that the tool reading it never
+// sees a partial configuration. When group is set the file is given to that
+// group, a failure only warns since the file is still usable by root.
+func writeFile(path string, content []byte, mode os.FileMode, group string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if group != "" {
+		if err := chgrp(tmp, group); err != nil {
+			log.Warnf("unable to give %s to the %s group, run chgrp %s %s: %s", path, group, group, path, err.Error())
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// chgrp gives f to the named group
+func chgrp(f *os.File, group string) error {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return err
+	}
+	return f.Chown(-1, gid)
+}
+
+func init() {
+	flags := exportCmd.Flags()
+	flags.String("format", wireguard.FormatWgQuick, "the output format: wg-quick, networkd or nm-keyfile")
+	flags.String("output", "", "the directory where the files are written, stdout if empty")
+	flags.StringSlice("dns", nil, "dns servers to configure on the interface")
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"wirey/backend"
+	"wirey/pkg/wireguard"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Import formats
+const (
+	importFormatAuto    = "auto"
+	importFormatWgQuick = "wg-quick"
+	importFormatDump    = "dump"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "import the peers of an existing wireguard mesh into the backend",
+	Long: `Read a wg-quick configuration or the output of "wg show <ifname> dump", from the given file or stdin,
+and write every peer in the configured backend, under --network-name, marked as static. The wirey nodes then
+configure them like any other peer, while the imported machines keep being managed by hand.
+
+Each peer needs a /32 allowed ip, used as its address inside the tunnel, the other allowed ips are kept.
+The [Interface] section, or the first line of the dump, is ignored.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		var in io.Reader = os.Stdin
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			in = f
+		}
+		content, err := ioutil.ReadAll(in)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		device, err := parseImport(format, content)
+		if err != nil {
+			log.Fatal(err)
+		}
+		peers, err := backend.StaticPeers(device)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		network := networkName()
+		var b backend.Backend
+		if !dryRun {
+			b, err = backendFactory()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		for _, p := range peers {
+			key := strings.TrimSpace(string(p.PublicKey))
+			fmt.Printf("%s ip=%s endpoint=%s allowed-ips=%s\n", key, p.IP.String(), p.Endpoint, strings.Join(p.AllowedIPs, ","))
+			if dryRun {
+				continue
+			}
+			if err := b.Join(context.Background(), network, p); err != nil {
+				log.Fatalf("unable to import the peer %s: %s", key, err.Error())
+			}
+		}
+		if dryRun {
+			fmt.Printf("%d peers would be imported in %s\n", len(peers), network)
+			return
+		}
+		fmt.Printf("%d peers imported in %s\n", len(peers), network)
+	},
+}
+
+// parseImport parses a wg-quick configuration or a `wg show dump`, with
+// auto a configuration is recognized by its first section header
+func parseImport(format string, content []byte) (*wireguard.Device, error) {
+	if format == importFormatAuto {
+		format = importFormatDump
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if strings.HasPrefix(line, "[") {
+				format = importFormatWgQuick
+			}
+			break
+		}
+	}
+
+	switch format {
+	case importFormatWgQuick:
+		return wireguard.ParseWgQuick(bytes.NewReader(content))
+	case importFormatDump:
+		return wireguard.ParseDump(bytes.NewReader(content))
+	}
+	return nil, fmt.Errorf("unknown import format %q, available formats: [%s, %s, %s]", format, importFormatAuto, importFormatWgQuick, importFormatDump)
+}
+
+func init() {
+	flags := importCmd.Flags()
+	flags.String("format", importFormatAuto, "the input format: auto, wg-quick or dump")
+	flags.Bool("dry-run", false, "only print the peers that would be imported")
+	rootCmd.AddCommand(importCmd)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"wirey/backend"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "move the records of the network to the current key schema",
+	Long: `Every backend stores the records of --network-name under the same versioned paths:
+
+  v1/{network}/peers/{sha256 of the public key}
+  v1/{network}/_config
+
+Older versions used other keys, etcd for instance stored the raw public key. The nodes only read the
+current schema: the first upgraded node copies the legacy records to it when joining and leaves them
+for the nodes not upgraded yet. Run migrate once every node is upgraded to rewrite the legacy records
+in place. A record already in the schema is kept and the legacy one removed, running migrate again does
+nothing.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		b, err := backendFactory()
+		if err != nil {
+			log.Fatal(err)
+		}
+		m, ok := b.(backend.KeyMigrator)
+		if !ok {
+			fmt.Println("the backend has no legacy keys to migrate")
+			return
+		}
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		network := networkName()
+		keys, err := m.MigrateKeys(context.Background(), network, backend.MigrateOptions{DryRun: dryRun})
+		for _, key := range keys {
+			fmt.Printf("%q\n", key)
+		}
+		if err != nil {
+			log.Fatalf("unable to migrate the keys of %s: %s", network, err.Error())
+		}
+		if dryRun {
+			fmt.Printf("%d keys would be migrated in %s\n", len(keys), network)
+			return
+		}
+		fmt.Printf("%d keys migrated in %s\n", len(keys), network)
+	},
+}
+
+// warnLegacyKeys tells when the network still has legacy records, which the
+// upgraded nodes no longer follow, until wirey migrate moves them
+func warnLegacyKeys(ctx context.Context, b backend.Backend, network string) {
+	m, ok := backend.AsKeyMigrator(b)
+	if !ok {
+		return
+	}
+	keys, err := m.MigrateKeys(ctx, network, backend.MigrateOptions{DryRun: true})
+	if err != nil {
+		log.Debugf("unable to look for legacy keys: %s", err.Error())
+		return
+	}
+	if len(keys) > 0 {
+		log.Warnf("%d records of %s use a legacy key, run wirey migrate once every node is upgraded", len(keys), network)
+	}
+}
+
+func init() {
+	migrateCmd.Flags().Bool("dry-run", false, "only print the keys that would be migrated")
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"wirey/backend"
+	"wirey/pkg/utils"
+	"wirey/pkg/wireguard"
+
+	"github.com/mdp/qrterminal/v3"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var peerCmd = &cobra.Command{
+	Use:   "peer",
+	Short: "manage static peers, like phones or appliances, that cannot run wirey",
+}
+
+var peerAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "register a static peer and print its client configuration",
+	Long: `Generate a keypair, allocate a tunnel ip (or use --ip) and write the peer in the backend, marked as static.
+The wirey nodes configure it at their next check.
+
+The printed wg-quick configuration, also as a QR code for the mobile apps, connects to the nodes selected with
+--via (all the wirey nodes by default). The private key is not stored anywhere: keep the configuration.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		flags := cmd.Flags()
+		name, _ := flags.GetString("name")
+		ipAddr, _ := flags.GetString("ip")
+		subnetFlag, _ := flags.GetString("subnet")
+		via, _ := flags.GetStringSlice("via")
+		dns, _ := flags.GetStringSlice("dns")
+		keepalive, _ := flags.GetInt("keepalive")
+		output, _ := flags.GetString("output")
+		noQR, _ := flags.GetBool("no-qr")
+
+		b, err := backendFactory()
+		if err != nil {
+			log.Fatal(err)
+		}
+		network := networkName()
+		ctx := context.Background()
+
+		peers, err := b.GetPeers(ctx, network)
+		if err != nil {
+			log.Fatalf("unable to get the peers from the backend: %s", err.Error())
+		}
+		for _, p := range peers {
+			if p.Name == name {
+				log.Fatalf("a peer named %s already exists", name)
+			}
+		}
+
+		nodes, err := viaNodes(peers, via)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ip, err := staticPeerIP(peers, ipAddr, subnetFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		privKey, err := wireguard.Genkey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		pubKey, err := wireguard.ExtractPubKey(privKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		peer := backend.Peer{
+			PublicKey:  pubKey,
+			IP:         &ip,
+			AllowedIPs: []string{},
+			Name:       name,
+			Static:     true,
+		}
+		if err := b.Join(ctx, network, peer); err != nil {
+			log.Fatalf("unable to write the peer in the backend: %s", err.Error())
+		}
+
+		conf := wireguard.Configuration{
+			Interface: wireguard.Interface{PrivateKey: string(privKey)},
+			Peers:     []wireguard.Peer{},
+		}
+		for _, n := range nodes {
+			allowedIPs := append([]string{fmt.Sprintf("%s/32", n.IP.String())}, n.AllowedIPs...)
+			conf.Peers = append(conf.Peers, wireguard.Peer{
+				PublicKey:  string(n.PublicKey),
+				AllowedIPs: strings.Join(allowedIPs, ","),
+				Endpoint:   n.Endpoint,
+			})
+		}
+		files, err := wireguard.Export(wireguard.FormatWgQuick, conf, wireguard.ExportOptions{
+			Name:                name,
+			Address:             fmt.Sprintf("%s/32", ip.String()),
+			DNS:                 dns,
+			PersistentKeepalive: keepalive,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		clientConf := files[0]
+
+		if output != "" {
+			if err := writeFile(output, clientConf.Content, clientConf.Mode, clientConf.Group); err != nil {
+				log.Fatalf("unable to write %s: %s", output, err.Error())
+			}
+			log.Infof("Written %s", output)
+		} else {
+			fmt.Printf("# %s\n%s\n", clientConf.Name, clientConf.Content)
+		}
+		if !noQR {
+			qrterminal.GenerateHalfBlock(string(clientConf.Content), qrterminal.L, os.Stdout)
+		}
+		fmt.Printf("peer %s added with ip %s and public key %s\n", name, ip.String(), strings.TrimSpace(string(pubKey)))
+		resyncDaemon()
+	},
+}
+
+var peerRemoveCmd = &cobra.Command{
+	Use:   "remove <name|public key|ip>",
+	Short: "remove a static peer from the backend",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		b, err := backendFactory()
+		if err != nil {
+			log.Fatal(err)
+		}
+		network := networkName()
+		ctx := context.Background()
+
+		peers, err := b.GetPeers(ctx, network)
+		if err != nil {
+			log.Fatalf("unable to get the peers from the backend: %s", err.Error())
+		}
+		peer, err := findPeer(peers, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !peer.Static {
+			log.Fatalf("%s is a wirey node, not a static peer: it would join again at its next check", args[0])
+		}
+		if err := b.Leave(ctx, network, peer.PublicKey); err != nil {
+			log.Fatalf("unable to remove the peer from the backend: %s", err.Error())
+		}
+		fmt.Printf("peer %s removed\n", args[0])
+		resyncDaemon()
+	},
+}
+
+var peerListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the static peers",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		b, err := backendFactory()
+		if err != nil {
+			log.Fatal(err)
+		}
+		peers, err := b.GetPeers(context.Background(), networkName())
+		if err != nil {
+			log.Fatalf("unable to get the peers from the backend: %s", err.Error())
+		}
+
+		static := []backend.Peer{}
+		for _, p := range peers {
+			if p.Static {
+				static = append(static, p)
+			}
+		}
+		sort.Slice(static, func(i, j int) bool { return static[i].Name < static[j].Name })
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tIP\tPUBLIC KEY\tENDPOINT\tALLOWED IPS")
+		for _, p := range static {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				dash(p.Name), p.IP.String(), strings.TrimSpace(string(p.PublicKey)), dash(p.Endpoint), dash(strings.Join(p.AllowedIPs, ",")))
+		}
+		w.Flush()
+	},
+}
+
+// viaNodes returns the wirey nodes a static peer connects to: the ones
+// matching via, or all of them when via is empty
+func viaNodes(peers []backend.Peer, via []string) ([]backend.Peer, error) {
+	nodes := []backend.Peer{}
+	if len(via) == 0 {
+		for _, p := range peers {
+			if !p.Static && p.Endpoint != "" {
+				nodes = append(nodes, p)
+			}
+		}
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("there are no wirey nodes to connect to in the backend")
+		}
+		return nodes, nil
+	}
+
+	for _, v := range via {
+		p, err := findPeer(peers, v)
+		if err != nil {
+			return nil, err
+		}
+		if p.Endpoint == "" {
+			return nil, fmt.Errorf("%s has no endpoint, it cannot be connected to", v)
+		}
+		nodes = append(nodes, p)
+	}
+	return nodes, nil
+}
+
+// staticPeerIP validates the requested ip or allocates one in subnet, which
+// defaults to the subnet of the wirey links
+func staticPeerIP(peers []backend.Peer, ipAddr, subnet string) (net.IP, error) {
+	if ipAddr != "" {
+		ip := net.ParseIP(ipAddr)
+		if ip == nil {
+			return nil, fmt.Errorf("the ip address %q is not valid", ipAddr)
+		}
+		for _, p := range peers {
+			if p.IP != nil && p.IP.Equal(ip) {
+				return nil, fmt.Errorf(`address already taken: %s`, ipAddr)
+			}
+		}
+		return ip, nil
+	}
+
+	if subnet == "" {
+		for _, p := range peers {
+			if p.IP != nil {
+				subnet = backend.LinkAddress(*p.IP)
+				break
+			}
+		}
+		if subnet == "" {
+			return nil, fmt.Errorf("there are no peers in the backend to take the subnet from, use --subnet or --ip")
+		}
+	}
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	return backend.AllocateIP(ipnet, peers)
+}
+
+// findPeer looks a peer up by name, public key, sha of the public key or ip
+func findPeer(peers []backend.Peer, ref string) (backend.Peer, error) {
+	for _, p := range peers {
+		if (p.Name != "" && p.Name == ref) ||
+			strings.TrimSpace(string(p.PublicKey)) == ref ||
+			utils.PublicKeySHA256(p.PublicKey) == ref ||
+			(p.IP != nil && p.IP.String() == ref) {
+			return p, nil
+		}
+	}
+	return backend.Peer{}, fmt.Errorf("no peer matching %s", ref)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	flags := peerAddCmd.Flags()
+	flags.String("name", "", "the name of the peer, e.g: phone-1")
+	flags.String("ip", "", "the ip of the peer inside the tunnel, allocated in --subnet if empty")
+	flags.String("subnet", "", "the subnet where the ip is allocated, defaults to the /24 of the existing peers")
+	flags.StringSlice("via", nil, "the wirey nodes (name, public key or ip) the peer connects to, all of them if empty")
+	flags.StringSlice("dns", nil, "dns servers for the client configuration")
+	flags.Int("keepalive", 25, "persistent keepalive, in seconds, for peers behind a NAT, 0 disables it")
+	flags.String("output", "", "write the client configuration to this file instead of stdout")
+	flags.Bool("no-qr", false, "do not print the client configuration as a QR code")
+	peerAddCmd.MarkFlagRequired("name")
+
+	peerCmd.AddCommand(peerAddCmd)
+	peerCmd.AddCommand(peerRemoveCmd)
+	peerCmd.AddCommand(peerListCmd)
+	rootCmd.AddCommand(peerCmd)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"wirey/backend"
+	"wirey/pkg/utils"
+	"wirey/pkg/wireguard"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Output formats of the peers subcommands
+const (
+	peersFormatTable = "table"
+	peersFormatJSON  = "json"
+)
+
+// peerView is how a backend record is shown by the peers subcommands
+type peerView struct {
+	PublicKey  string   `json:"publicKey"`
+	SHA        string   `json:"sha"`
+	IP         string   `json:"ip"`
+	Endpoint   string   `json:"endpoint"`
+	AllowedIPs []string `json:"allowedIPs"`
+	Name       string   `json:"name,omitempty"`
+	Static     bool     `json:"static"`
+}
+
+func newPeerView(p backend.Peer) peerView {
+	v := peerView{
+		PublicKey:  strings.TrimSpace(string(p.PublicKey)),
+		SHA:        utils.PublicKeySHA256(p.PublicKey),
+		Endpoint:   p.Endpoint,
+		AllowedIPs: p.AllowedIPs,
+		Name:       p.Name,
+		Static:     p.Static,
+	}
+	if v.AllowedIPs == nil {
+		v.AllowedIPs = []string{}
+	}
+	if p.IP != nil {
+		v.IP = p.IP.String()
+	}
+	return v
+}
+
+var peersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "inspect and edit the peers stored in the backend",
+	Long: `Inspect and edit the records of the configured backend, whichever it is, for --network-name.
+Peers are referenced by name, public key, sha of the public key or ip.`,
+}
+
+var peersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the peers in the backend",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		peers := backendPeers()
+		views := []peerView{}
+		for _, p := range peers {
+			views = append(views, newPeerView(p))
+		}
+		sort.Slice(views, func(i, j int) bool {
+			return bytes.Compare(net.ParseIP(views[i].IP), net.ParseIP(views[j].IP)) < 0
+		})
+		format, _ := cmd.Flags().GetString("format")
+		if err := printPeers(os.Stdout, format, views); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var peersGetCmd = &cobra.Command{
+	Use:   "get <name|public key|sha|ip>",
+	Short: "show a single peer",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		peer, err := findPeer(backendPeers(), args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		format, _ := cmd.Flags().GetString("format")
+		if format == peersFormatJSON {
+			err = printJSON(os.Stdout, newPeerView(peer))
+		} else {
+			err = printPeers(os.Stdout, format, []peerView{newPeerView(peer)})
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var peersRemoveCmd = &cobra.Command{
+	Use:   "remove <name|public key|sha|ip>...",
+	Short: "remove peers from the backend",
+	Long: `Remove records from the backend, static or not. A wirey node joins again when it restarts,
+stop it first to remove it for good.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		b, err := backendFactory()
+		if err != nil {
+			log.Fatal(err)
+		}
+		network := networkName()
+		peers, err := b.GetPeers(context.Background(), network)
+		if err != nil {
+			log.Fatalf("unable to get the peers from the backend: %s", err.Error())
+		}
+
+		// resolve everything first, so that a typo removes nothing
+		toRemove := []backend.Peer{}
+		for _, ref := range args {
+			p, err := findPeer(peers, ref)
+			if err != nil {
+				log.Fatal(err)
+			}
+			toRemove = append(toRemove, p)
+		}
+		for i, p := range toRemove {
+			if err := b.Leave(context.Background(), network, p.PublicKey); err != nil {
+				log.Fatalf("unable to remove %s: %s", args[i], err.Error())
+			}
+			fmt.Printf("removed %s\n", strings.TrimSpace(string(p.PublicKey)))
+		}
+		resyncDaemon()
+	},
+}
+
+var peersPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "remove the peers this node has not had a handshake with for a while",
+	Long: `Compare the backend with the wireguard device of this node and remove the peers whose latest handshake
+is older than --older-than. Peers the device does not know about are left alone, as well as static peers unless
+--static is set. A peer that never completed a handshake is only removed with --include-never-seen: on a node that
+just started, or that is cut from the network, that is every peer. Run it on a node that has been up for longer
+than --older-than.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+		static, _ := cmd.Flags().GetBool("static")
+		neverSeen, _ := cmd.Flags().GetBool("include-never-seen")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		b, err := backendFactory()
+		if err != nil {
+			log.Fatal(err)
+		}
+		network := networkName()
+		peers, err := b.GetPeers(context.Background(), network)
+		if err != nil {
+			log.Fatalf("unable to get the peers from the backend: %s", err.Error())
+		}
+		ifname := viper.GetString("ifname")
+		device, err := showDevice(ifname)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if device == nil {
+			log.Fatalf("the device %s does not exist", ifname)
+		}
+
+		stale := stalePeers(peers, device, olderThan, static, neverSeen, time.Now())
+		for _, p := range stale {
+			key := strings.TrimSpace(string(p.PublicKey))
+			if dryRun {
+				fmt.Printf("would remove %s\n", key)
+				continue
+			}
+			if err := b.Leave(context.Background(), network, p.PublicKey); err != nil {
+				log.Fatalf("unable to remove %s: %s", key, err.Error())
+			}
+			fmt.Printf("removed %s\n", key)
+		}
+		if len(stale) > 0 && !dryRun {
+			resyncDaemon()
+		}
+	},
+}
+
+// stalePeers returns the peers configured on device whose latest handshake
+// is older than olderThan, the peers without any handshake only when
+// neverSeen is set
+func stalePeers(peers []backend.Peer, device *wireguard.Device, olderThan time.Duration, static, neverSeen bool, now time.Time) []backend.Peer {
+	handshakes := map[string]time.Time{}
+	for _, dp := range device.Peers {
+		handshakes[dp.PublicKey] = dp.LatestHandshake
+	}
+
+	stale := []backend.Peer{}
+	for _, p := range peers {
+		if p.Static && !static {
+			continue
+		}
+		handshake, ok := handshakes[strings.TrimSpace(string(p.PublicKey))]
+		if !ok || (handshake.IsZero() && !neverSeen) || now.Sub(handshake) < olderThan {
+			continue
+		}
+		stale = append(stale, p)
+	}
+	return stale
+}
+
+// backendPeers returns the peers of the configured backend and network
+func backendPeers() []backend.Peer {
+	b, err := backendFactory()
+	if err != nil {
+		log.Fatal(err)
+	}
+	peers, err := b.GetPeers(context.Background(), networkName())
+	if err != nil {
+		log.Fatalf("unable to get the peers from the backend: %s", err.Error())
+	}
+	return peers
+}
+
+func printPeers(w io.Writer, format string, views []peerView) error {
+	switch format {
+	case peersFormatJSON:
+		return printJSON(w, views)
+	case peersFormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PUBLIC KEY\tSHA\tIP\tENDPOINT\tALLOWED IPS\tNAME\tSTATIC")
+		for _, v := range views {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%t\n",
+				v.PublicKey, v.SHA[:12], dash(v.IP), dash(v.Endpoint), dash(strings.Join(v.AllowedIPs, ",")), dash(v.Name), v.Static)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown format %q, available formats: [%s, %s]", format, peersFormatTable, peersFormatJSON)
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func init() {
+	for _, c := range []*cobra.Command{peersListCmd, peersGetCmd} {
+		c.Flags().String("format", peersFormatTable, "the output format: table or json")
+	}
+	peersPruneCmd.Flags().Duration("older-than", 24*time.Hour, "remove the peers without a handshake for longer than this")
+	peersPruneCmd.Flags().Bool("static", false, "prune static peers too")
+	peersPruneCmd.Flags().Bool("include-never-seen", false, "prune the peers that never completed a handshake too")
+	peersPruneCmd.Flags().Bool("dry-run", false, "only print the peers that would be removed")
+
+	peersCmd.AddCommand(peersListCmd)
+	peersCmd.AddCommand(peersGetCmd)
+	peersCmd.AddCommand(peersRemoveCmd)
+	peersCmd.AddCommand(peersPruneCmd)
+	rootCmd.AddCommand(peersCmd)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"wirey/backend"
+	"wirey/pkg/wireguard"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStalePeersSkipsNeverSeen(t *testing.T) {
+	now := time.Now()
+	peers := []backend.Peer{
+		{PublicKey: []byte("recent\n")},
+		{PublicKey: []byte("old\n")},
+		{PublicKey: []byte("never\n")},
+		{PublicKey: []byte("unknown\n")},
+		{PublicKey: []byte("static\n"), Static: true},
+	}
+	device := &wireguard.Device{Peers: []wireguard.DevicePeer{
+		{PublicKey: "recent", LatestHandshake: now.Add(-time.Minute)},
+		{PublicKey: "old", LatestHandshake: now.Add(-48 * time.Hour)},
+		{PublicKey: "never"},
+		{PublicKey: "static", LatestHandshake: now.Add(-48 * time.Hour)},
+	}}
+
+	keys := func(peers []backend.Peer) []string {
+		k := []string{}
+		for _, p := range peers {
+			k = append(k, string(p.PublicKey))
+		}
+		return k
+	}
+
+	assert.Equal(t, []string{"old\n"}, keys(stalePeers(peers, device, 24*time.Hour, false, false, now)),
+		"a peer without a handshake is not stale, every peer looks like that on a node that just started")
+	assert.Equal(t, []string{"old\n", "never\n"}, keys(stalePeers(peers, device, 24*time.Hour, false, true, now)))
+	assert.Equal(t, []string{"old\n", "static\n"}, keys(stalePeers(peers, device, 24*time.Hour, true, false, now)))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"wirey/backend"
+	"wirey/pkg/wireguard"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/vishvananda/netlink"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "show the wireguard configuration wirey would apply and how it differs from the current device, without changing anything",
+	Long: `Fetch the peers from the configured backend, build the same configuration the daemon applies and
+compare it with the current state of the device. Nothing is written, neither in the backend nor on the device.
+
+When the interface lives in another network namespace, run plan inside it (e.g. ip netns exec <name> wirey plan).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		b, err := backendFactory()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ifname := viper.GetString("ifname")
+
+		// the device does not exist before the first run
+		var device *wireguard.Device
+		link, _ := netlink.LinkByName(ifname)
+		if link != nil {
+			device, err = wireguard.Show(ifname)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		privKey, err := planPrivateKey(device)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		conf, ip, err := desiredConfiguration(b, privKey, cmd.Flags())
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// never print the private key
+		conf.Interface.PrivateKey = "(hidden)"
+		rendered, err := wireguard.RenderConfiguration(conf)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("# configuration for %s\n%s\n", ifname, rendered)
+		printPlan(os.Stdout, link, device, conf, backend.LinkAddress(ip))
+	},
+}
+
+// planPrivateKey prefers the key of the running device, so that plan does
+// not need to read (or generate) the private key file
+func planPrivateKey(device *wireguard.Device) ([]byte, error) {
+	if device != nil && device.PrivateKey != "" {
+		return []byte(device.PrivateKey + "\n"), nil
+	}
+
+	privKey, err := ioutil.ReadFile(viper.GetString("privatekeypath"))
+	if err != nil {
+		return nil, fmt.Errorf("the device does not exist and the private key cannot be read: %s", err.Error())
+	}
+	return privKey, nil
+}
+
+// desiredConfiguration builds the configuration the daemon would apply with
+// the network settings, the given private key and the peers currently in the
+// backend. It also returns the ip of this node inside the tunnel.
+func desiredConfiguration(b backend.Backend, privKey []byte, flags *pflag.FlagSet) (wireguard.Configuration, net.IP, error) {
+	if _, err := loadSettings(context.Background(), b, networkName(), flags); err != nil {
+		return wireguard.Configuration{}, nil, fmt.Errorf("unable to apply the network settings: %s", err.Error())
+	}
+	endpoint, ipAddr, allowedIps, err := localNode()
+	if err != nil {
+		return wireguard.Configuration{}, nil, err
+	}
+	ip := net.ParseIP(ipAddr)
+	if ip == nil {
+		return wireguard.Configuration{}, nil, fmt.Errorf("the ip address %q is not valid", ipAddr)
+	}
+
+	pubKey, err := wireguard.ExtractPubKey(privKey)
+	if err != nil {
+		return wireguard.Configuration{}, nil, err
+	}
+
+	peers, err := b.GetPeers(context.Background(), networkName())
+	if err != nil {
+		return wireguard.Configuration{}, nil, fmt.Errorf("unable to get the peers from the backend: %s", err.Error())
+	}
+
+	local := backend.Peer{
+		PublicKey:  pubKey,
+		Endpoint:   endpoint,
+		IP:         &ip,
+		AllowedIPs: allowedIps,
+	}
+	conf, err := backend.NewConfiguration(local, privKey, peers)
+	if err != nil {
+		return wireguard.Configuration{}, nil, err
+	}
+	return conf, ip, nil
+}
+
+func printPlan(w io.Writer, link netlink.Link, device *wireguard.Device, conf wireguard.Configuration, address string) {
+	fmt.Fprintln(w, "# changes")
+
+	changes := 0
+	if link == nil {
+		fmt.Fprintf(w, "+ link (does not exist)\n")
+		changes++
+	}
+
+	currentAddresses := []string{}
+	currentRoutes := []string{}
+	if link != nil {
+		addrs, _ := netlink.AddrList(link, netlink.FAMILY_V4)
+		for _, a := range addrs {
+			currentAddresses = append(currentAddresses, a.IPNet.String())
+		}
+		routes, _ := netlink.RouteList(link, netlink.FAMILY_V4)
+		for _, r := range routes {
+			if r.Dst != nil {
+				currentRoutes = append(currentRoutes, r.Dst.String())
+			}
+		}
+	}
+	changes += printListChange(w, "address", currentAddresses, address)
+
+	// the only route wirey relies on is the one of the link subnet
+	route := address
+	if _, subnet, err := net.ParseCIDR(address); err == nil {
+		route = subnet.String()
+	}
+	changes += printListChange(w, "route", currentRoutes, route)
+
+	diff := wireguard.Diff(device, conf)
+	if !diff.Empty() {
+		changes++
+	}
+	if diff.CurrentListenPort != diff.DesiredListenPort {
+		fmt.Fprintf(w, "~ listen port %d -> %d\n", diff.CurrentListenPort, diff.DesiredListenPort)
+	}
+	for _, p := range diff.Added {
+		fmt.Fprintf(w, "+ peer %s endpoint=%s allowed-ips=%s\n", p.PublicKey, p.Endpoint, p.AllowedIPs)
+	}
+	for _, p := range diff.Removed {
+		fmt.Fprintf(w, "- peer %s endpoint=%s allowed-ips=%s\n", p.PublicKey, p.Endpoint, p.AllowedIPs)
+	}
+	for _, c := range diff.Changed {
+		fields := []string{}
+		if c.Current.Endpoint != c.Desired.Endpoint {
+			fields = append(fields, fmt.Sprintf("endpoint=%s -> %s", c.Current.Endpoint, c.Desired.Endpoint))
+		}
+		if c.Current.AllowedIPs != c.Desired.AllowedIPs {
+			fields = append(fields, fmt.Sprintf("allowed-ips=%s -> %s", c.Current.AllowedIPs, c.Desired.AllowedIPs))
+		}
+		fmt.Fprintf(w, "~ peer %s %s\n", c.Desired.PublicKey, strings.Join(fields, " "))
+	}
+
+	if changes == 0 {
+		fmt.Fprintln(w, "no changes, the device is up to date")
+	}
+}
+
+// printListChange prints how current becomes the single desired value and
+// returns the number of changes
+func printListChange(w io.Writer, what string, current []string, desired string) int {
+	changes := 0
+	found := false
+	for _, c := range current {
+		if c == desired {
+			found = true
+			continue
+		}
+		fmt.Fprintf(w, "- %s %s\n", what, c)
+		changes++
+	}
+	if !found {
+		fmt.Fprintf(w, "+ %s %s\n", what, desired)
+		changes++
+	}
+	return changes
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+}
@@ -1,20 +1,34 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"wirey/backend"
+	"wirey/pkg/control"
+	"wirey/pkg/dataplane"
+	"wirey/pkg/health"
+	"wirey/pkg/hooks"
+	"wirey/pkg/metrics"
+	"wirey/pkg/systemd"
+	"wirey/pkg/webhook"
+	"wirey/pkg/wireguard"
 
 	socktmpl "github.com/hashicorp/go-sockaddr/template"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -27,13 +41,7 @@ var rootCmd = &cobra.Command{
 	Use:   "wirey",
 	Short: "manage local wireguard interfaces in a distributed system",
 	Run: func(cmd *cobra.Command, args []string) {
-		// Lookup user provided log-level
-		level, err := log.ParseLevel(viper.GetString("log-level"))
-		if err != nil {
-			log.Warn(err)
-		}
-		// Set logrus loglevel based on flags
-		log.SetLevel(level)
+		setLogLevel()
 
 		b, err := backendFactory()
 
@@ -49,73 +57,370 @@ var rootCmd = &cobra.Command{
 			}
 		}
 
+		// nothing waits for the backend before Connect, which brings the
+		// interface up from the cached peers first
 		ifname := viper.GetString("ifname")
-		endpoint := viper.GetString("endpoint")
-		endpointPort := viper.GetString("endpoint-port")
-		ipAddr := viper.GetString("ipaddr")
+		network := networkName()
 
-		// Endpoint
-		endpoint, err = socktmpl.Parse(endpoint)
+		endpoint, ipAddr, allowedIps, err := localNode()
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		// IP Address
-		ipAddr, err = socktmpl.Parse(ipAddr)
+		// Check peer discovery ttl
+		peerDiscoveryTTL, err := time.ParseDuration(settingString("peerdiscoveryttl"))
+		if err != nil {
+			log.Fatalf("The passed duration (peerdiscoveryttl) cannot be parsed: %s", err.Error())
+		}
+
+		i, err := backend.NewInterface(
+			b,
+			ifname,
+			endpoint,
+			ipAddr,
+			privateKeyPath,
+			peerDiscoveryTTL,
+			allowedIps,
+		)
+
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		// Check peer discovery ttl
-		peerDiscoveryTTL, err := time.ParseDuration(viper.GetString("peerdiscoveryttl"))
+		dp, err := newDataplane()
 		if err != nil {
-			log.Fatalf("The passed duration (peerdiscoveryttl) cannot be parsed: %s", err.Error())
+			log.Fatal(err)
+		}
+		i.Network = network
+		i.Dataplane = dp
+		i.MTU = settingInt("mtu")
+
+		var m *metrics.Metrics
+		if addr := viper.GetString("metrics-listen"); addr != "" {
+			var show func(string) (*wireguard.Device, error)
+			if r, ok := dp.(dataplane.DeviceReader); ok {
+				show = r.Show
+			}
+			m = metrics.New(ifname, show)
+			i.Backend = backend.InstrumentBackend(i.Backend, m)
+			i.Recorder = m
 		}
 
-		// Allowed IPs
-		allowedIps := viper.GetStringSlice("allowedips")
-		allowedIpsList := make([]string, 0)
+		peerCachePath := viper.GetString("peercachepath")
+		if peerCachePath == "" {
+			peerCachePath = filepath.Join(privKeyBaseDir, fmt.Sprintf("peers-%s.json", ifname))
+		}
+		i.PeerCache = backend.NewPeerCache(peerCachePath, network)
+		i.ApplyPolicy = applyPolicy()
+		i.OnReload = func(i *backend.Interface) error {
+			return reloadInterface(i, cmd.Flags())
+		}
+		if h := hookCommands(cmd.Flags()); h != nil {
+			i.Observers = append(i.Observers, h)
+		}
 
-		for _, v := range allowedIps {
-			_, _, err := net.ParseCIDR(v)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// the settings are read by the join, the record of a fresh node
+		// follows them, and watched once the node joined
+		if store, ok := i.Backend.(backend.SettingsStore); ok {
+			var watching sync.Once
+			i.OnJoin = func(ctx context.Context, i *backend.Interface) error {
+				s, err := joinSettings(ctx, i, store, cmd.Flags())
+				if err != nil {
+					return err
+				}
+				watching.Do(func() {
+					go watchSettings(ctx, i, store, peerDiscoveryTTL, s)
+				})
+				return nil
+			}
+		}
+		go warnLegacyKeys(ctx, i.Backend, network)
 
+		if url := viper.GetString("webhook-url"); url != "" {
+			w, err := webhook.New(url, []byte(viper.GetString("webhook-secret")), viper.GetString("webhook-report"), i.LocalPeer.PublicKey)
 			if err != nil {
-				log.Errorf("Not valid allowed ip. %s\n", err)
-				continue
+				log.Fatal(err)
 			}
+			w.Client.Timeout = viper.GetDuration("webhook-timeout")
+			i.Observers = append(i.Observers, w)
+			go w.Run(ctx)
+		}
 
-			allowedIpsList = append(allowedIpsList, v)
+		// metrics and probes share the listener when they use the same address
+		muxes := map[string]*http.ServeMux{}
+		mux := func(addr string) *http.ServeMux {
+			if _, ok := muxes[addr]; !ok {
+				muxes[addr] = http.NewServeMux()
+			}
+			return muxes[addr]
+		}
+		if m != nil {
+			mux(viper.GetString("metrics-listen")).Handle("/metrics", m.Handler())
+		}
+		if addr := viper.GetString("health-listen"); addr != "" {
+			h := health.Handler(i, health.Options{
+				LivenessGracePeriod: viper.GetDuration("health-liveness-grace"),
+				BackendGracePeriod:  viper.GetDuration("health-backend-grace"),
+			})
+			mux(addr).Handle(health.LivenessPath, h)
+			mux(addr).Handle(health.ReadinessPath, h)
+		}
+		for addr, handler := range muxes {
+			go serveHTTP(ctx, addr, handler)
 		}
 
-		i, err := backend.NewInterface(
-			b,
-			ifname,
-			fmt.Sprintf("%s:%s", endpoint, endpointPort),
-			ipAddr,
-			privateKeyPath,
-			peerDiscoveryTTL,
-			allowedIps,
-		)
+		if socket := controlSocket(); socket != "" {
+			go func() {
+				if err := control.NewServer(socket, i).ListenAndServe(ctx); err != nil {
+					log.Fatalf("unable to start the control api: %s", err.Error())
+				}
+			}()
+		}
 
+		notifier, err := systemd.NewNotifier(i)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if notifier != nil {
+			go notifier.Run(ctx)
+		}
 
-		log.Fatal(i.Connect())
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-sigs
+			log.Infof("Received %s, stopping", sig)
+			cancel()
+		}()
+
+		if err := i.Connect(ctx); err != nil {
+			log.Fatal(err)
+		}
 	},
 }
 
+// serveHTTP serves handler on addr until the context is cancelled
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Infof("Listening on %s", addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("unable to listen on %s: %s", addr, err.Error())
+	}
+}
+
+// hookFlags maps the flags of the hooks to their events
+var hookFlags = map[string]string{
+	"hook-post-up":      backend.EventPostUp,
+	"hook-pre-down":     backend.EventPreDown,
+	"hook-peer-added":   backend.EventPeerAdded,
+	"hook-peer-removed": backend.EventPeerRemoved,
+	"hook-peer-changed": backend.EventPeerChanged,
+}
+
+// hookCommands returns the configured hooks, nil if there are none
+func hookCommands(flags *pflag.FlagSet) *hooks.Hooks {
+	commands := map[string][]string{}
+	for flag, event := range hookFlags {
+		if c := stringArray(flags, flag); len(c) > 0 {
+			commands[event] = c
+		}
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+	h := hooks.New(commands)
+	h.Timeout = viper.GetDuration("hook-timeout")
+	return h
+}
+
+// stringArray reads a repeatable flag, or the list with the same name in
+// the config file. The flag is not bound to viper, which would split its
+// values on spaces.
+func stringArray(flags *pflag.FlagSet, name string) []string {
+	if f := flags.Lookup(name); f != nil && f.Changed {
+		v, _ := flags.GetStringArray(name)
+		return v
+	}
+	return viper.GetStringSlice(name)
+}
+
+// applyPolicy returns how often the peer changes are applied
+func applyPolicy() backend.ApplyPolicy {
+	return backend.ApplyPolicy{
+		Debounce:      viper.GetDuration("debounce"),
+		MaxDelay:      viper.GetDuration("debounce-max-delay"),
+		MinInterval:   viper.GetDuration("min-apply-interval"),
+		FlapThreshold: viper.GetInt("flap-threshold"),
+		FlapWindow:    viper.GetDuration("flap-window"),
+	}
+}
+
+// reloadInterface reads the configuration and the network settings again
+// and applies what can change while running: the local peer, the mtu, the
+// peer discovery ttl, the apply policy and the log level
+func reloadInterface(i *backend.Interface, flags *pflag.FlagSet) error {
+	if err := viper.ReadInConfig(); err != nil {
+		log.Warn(err)
+	}
+	setLogLevel()
+	if _, err := loadSettings(context.Background(), i.Backend, i.Network, flags); err != nil {
+		log.Warnf("Unable to apply the network settings, keeping the previous ones: %s", err.Error())
+	}
+	if err := updateInterface(i); err != nil {
+		return err
+	}
+	log.Infoln("Configuration reloaded")
+	return nil
+}
+
+// updateInterface sets the fields of i that follow the configuration and
+// the network settings
+func updateInterface(i *backend.Interface) error {
+	peerDiscoveryTTL, err := time.ParseDuration(settingString("peerdiscoveryttl"))
+	if err != nil {
+		return fmt.Errorf("The passed duration (peerdiscoveryttl) cannot be parsed: %s", err.Error())
+	}
+	endpoint, ipAddr, allowedIps, err := localNode()
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(ipAddr)
+	if ip == nil {
+		return fmt.Errorf("the ip address %q is not valid", ipAddr)
+	}
+
+	i.PeerCheckTTL = peerDiscoveryTTL
+	i.ApplyPolicy = applyPolicy()
+	i.MTU = settingInt("mtu")
+	i.LocalPeer.Endpoint = endpoint
+	i.LocalPeer.IP = &ip
+	i.LocalPeer.AllowedIPs = allowedIps
+	return nil
+}
+
+// networkName returns the name of the network in the backend, the ifname
+// when it is not set
+func networkName() string {
+	if network := viper.GetString("network-name"); network != "" {
+		return network
+	}
+	return viper.GetString("ifname")
+}
+
+// newDataplane returns the dataplane selected by the flags
+func newDataplane() (dataplane.Dataplane, error) {
+	return dataplane.New(viper.GetString("dataplane"), dataplane.Options{
+		WireguardGo: viper.GetString("wireguard-go"),
+		ConfigDir:   viper.GetString("dataplane-configdir"),
+		Netns:       viper.GetString("netns"),
+	})
+}
+
+// showDevice reads the device through the dataplane, in its network
+// namespace, like the daemon does. It returns nil when the device does not
+// exist.
+func showDevice(ifname string) (*wireguard.Device, error) {
+	dp, err := newDataplane()
+	if err != nil {
+		return nil, err
+	}
+	r, ok := dp.(dataplane.DeviceReader)
+	if !ok {
+		return nil, fmt.Errorf("the %s dataplane cannot read the device", viper.GetString("dataplane"))
+	}
+	device, err := r.Show(ifname)
+	if err == dataplane.ErrNoDevice {
+		return nil, nil
+	}
+	return device, err
+}
+
+// controlSocket returns the path of the control api socket, empty when it
+// is disabled
+func controlSocket() string {
+	socket := viper.GetString("control-socket")
+	switch socket {
+	case "none":
+		return ""
+	case "":
+		return control.SocketPath(viper.GetString("ifname"))
+	}
+	return socket
+}
+
+func setLogLevel() {
+	// Lookup user provided log-level
+	level, err := log.ParseLevel(viper.GetString("log-level"))
+	if err != nil {
+		log.Warn(err)
+	}
+	// Set logrus loglevel based on flags
+	log.SetLevel(level)
+}
+
+// localNode returns the endpoint (ip:port), the ip address inside the tunnel
+// and the valid allowed ips of this node, as configured by the user
+func localNode() (string, string, []string, error) {
+	endpoint := viper.GetString("endpoint")
+	endpointPort := settingString("endpoint-port")
+	ipAddr := viper.GetString("ipaddr")
+
+	// Endpoint
+	endpoint, err := socktmpl.Parse(endpoint)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	// IP Address
+	ipAddr, err = socktmpl.Parse(ipAddr)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	// Allowed IPs
+	allowedIps := settingStrings("allowedips")
+	allowedIpsList := make([]string, 0)
+
+	for _, v := range allowedIps {
+		_, _, err := net.ParseCIDR(v)
+
+		if err != nil {
+			log.Errorf("Not valid allowed ip. %s\n", err)
+			continue
+		}
+
+		allowedIpsList = append(allowedIpsList, v)
+	}
+
+	return fmt.Sprintf("%s:%s", endpoint, endpointPort), ipAddr, allowedIpsList, nil
+}
+
 func backendFactory() (backend.Backend, error) {
 
 	etcdBackend := viper.GetStringSlice("etcd")
 	etcdPortBackend := viper.GetInt("etcd-port")
+	etcdTimeout := viper.GetDuration("etcd-timeout")
 	consulBackend := viper.GetString("consul")
 	consulPortBackend := viper.GetInt("consul-port")
 	consulAddressBackend := viper.GetString("consul-address")
 	consulTokenBackend := viper.GetString("consul-token")
+	consulTimeout := viper.GetDuration("consul-timeout")
 	httpBackend := viper.GetString("http")
+	httpTimeout := viper.GetDuration("http-timeout")
 	//httpPortBackend := viper.GetInt("http-port")
 	discoverConf := viper.GetString("discover")
+	prefix := viper.GetString("backend-prefix")
+	legacyPrefix := viper.GetString("backend-legacy-prefix")
 
 	// discover
 	discoverHosts := []string{}
@@ -147,10 +452,16 @@ func backendFactory() (backend.Backend, error) {
 
 	// etcd backend
 	if len(etcdBackend) > 0 {
-		b, err := backend.NewEtcdBackend(etcdBackend)
+		b, err := backend.NewEtcdBackend(etcdBackend, etcdTimeout)
 		if err != nil {
 			return nil, err
 		}
+		if prefix != "" {
+			b.Prefix = prefix
+		}
+		if legacyPrefix != "" {
+			b.LegacyPrefix = legacyPrefix
+		}
 		return b, nil
 	}
 
@@ -169,19 +480,28 @@ func backendFactory() (backend.Backend, error) {
 		b, err := backend.NewConsulBackend(
 			consulAddressBackend,
 			consulTokenBackend,
+			consulTimeout,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if prefix != "" {
+			b.Prefix = prefix
+		}
+		if legacyPrefix != "" {
+			b.LegacyPrefix = legacyPrefix
+		}
 		return b, nil
 	}
 
 	// http backend
 	if len(httpBackend) != 0 {
-		b, err := backend.NewHTTPBackend(httpBackend, Version)
+		b, err := backend.NewHTTPBackend(httpBackend, Version, httpTimeout)
 		if err != nil {
 			return nil, err
 		}
+		b.Prefix = prefix
+		b.LegacyPrefix = legacyPrefix
 		httpBackendBasicAuth := viper.GetString("httpbasicauth")
 		if len(httpBackendBasicAuth) > 0 {
 			splitted := strings.Split(httpBackendBasicAuth, ":")
@@ -222,19 +542,52 @@ func init() {
 	pflags.String("endpoint-port", "2345", "endpoint port for this machine")
 	pflags.StringSlice("etcd", nil, "array of etcd servers to connect to")
 	pflags.Int("etcd-port", 2379, "etcd port number")
+	pflags.Duration("etcd-timeout", 1*time.Second, "timeout for every call made to etcd")
 	pflags.String("consul", "", "consul server to connect to, e.g: 127.0.0.1")
 	pflags.Int("consul-port", 8500, "consul port number")
 	pflags.String("consul-address", "", "consul address (overrides host and port)")
 	pflags.String("consul-token", "", "consul acl token")
+	pflags.Duration("consul-timeout", 10*time.Second, "timeout for every call made to consul")
 	pflags.String("http", "", "the http backend endpoint to use as backend, see also httpbasicauth if you need basic authentication")
 	pflags.Int("http-port", 80, "http port number")
 	pflags.String("httpbasicauth", "", "basic auth for the http backend, in form username:password")
-	pflags.String("ifname", "wg0", "the name to use for the interface (must be the same in all the peers)")
+	pflags.Duration("http-timeout", 10*time.Second, "timeout for every request made to the http backend")
+	pflags.String("backend-prefix", "", "the root of the records in the backend, wirey by default in etcd and consul, appended to the base url of the http backend, / for none")
+	pflags.String("backend-legacy-prefix", "", "the root of the records written before the key schema, which wirey migrate moves under --backend-prefix, wirey by default in etcd and consul, / for none")
+	pflags.String("ifname", "wg0", "the name of the local wireguard interface")
+	pflags.String("network-name", "", "the name of the network in the backend, it must be the same in all the peers. Defaults to the ifname")
 	pflags.String("ipaddr", "", "the ip for this node inside the tunnel, e.g: 10.0.0.3")
 	pflags.String("peerdiscoveryttl", "30s", "the time to wait to discover new peers using the configured backend")
 	pflags.String("privatekeypath", "/etc/wirey/privkey", "the local path where to load the private key from, if empty, a private key will be generated.")
+	pflags.String("peercachepath", "", "the local path where the last applied peers are cached, used to bring the interface up while the backend is not reachable. Defaults to peers-<ifname>.json next to the private key.")
 	pflags.String("discover", "", "discover configuration from the provider. e.g: provider=aws region=eu-west-1 ... Check go-discover for all the options.")
 	pflags.StringSlice("allowedips", nil, "array of allowed ips")
+	pflags.String("dataplane", dataplane.DriverAuto, "how the wireguard interface is managed: auto, kernel, userspace (wireguard-go) or configfile. auto uses the kernel module and falls back to userspace when it is missing")
+	pflags.String("wireguard-go", "", "an external wireguard-go executable run by the userspace dataplane instead of the embedded implementation")
+	pflags.String("dataplane-configdir", dataplane.DefaultConfigDir, "the directory where the configfile dataplane writes <ifname>.conf")
+	pflags.String("netns", "", "network namespace (name or path) where the interface is moved to, its UDP socket stays in the current namespace")
+	pflags.Duration("debounce", 0, "how long the peers must stay the same before a change is applied, e.g. while many nodes join at once. 0 applies every change right away")
+	pflags.Duration("debounce-max-delay", time.Minute, "the longest a change waits for the peers to settle, 0 waits until they do")
+	pflags.Duration("min-apply-interval", 0, "the minimum time between two changes applied to the link")
+	pflags.Int("flap-threshold", 0, "how many times a peer can appear or disappear within flap-window before its changes are held until it is stable. 0 disables the damping")
+	pflags.Duration("flap-window", 10*time.Minute, "the window counting the appearances and disappearances of a peer")
+	pflags.Int("mtu", 0, "the mtu of the link, the dataplane default if 0")
+	pflags.StringSlice("local-overrides", []string{"allowedips"}, "the network settings that the local flags and configuration file can override, the others always follow the settings stored in the backend")
+	pflags.String("control-socket", "", "the unix socket of the control api, used by the other subcommands to reach the daemon. Defaults to /run/wirey/<ifname>.sock, none disables it")
+	pflags.String("metrics-listen", "", "address where prometheus metrics are served on /metrics, e.g: :9586. Disabled if empty")
+	pflags.String("health-listen", "", "address where the /healthz and /readyz probes are served, can be the same as metrics-listen. Disabled if empty")
+	pflags.Duration("health-liveness-grace", health.DefaultGracePeriod, "how late the reconcile loop can be before /healthz fails")
+	pflags.Duration("health-backend-grace", health.DefaultGracePeriod, "how long the backend can be unreachable before /readyz fails")
+	pflags.StringArray("hook-post-up", nil, "shell command run after the link is brought up, can be repeated. Every hook gets the event as JSON on stdin")
+	pflags.StringArray("hook-pre-down", nil, "shell command run before the link is recreated and when wirey stops, can be repeated")
+	pflags.StringArray("hook-peer-added", nil, "shell command run for every peer added to the link, can be repeated")
+	pflags.StringArray("hook-peer-removed", nil, "shell command run for every peer removed from the link, can be repeated")
+	pflags.StringArray("hook-peer-changed", nil, "shell command run for every peer whose record changed, can be repeated")
+	pflags.Duration("hook-timeout", hooks.DefaultTimeout, "how long a hook can run before it is killed, the reconcile loop waits for the hooks")
+	pflags.String("webhook-url", "", "url receiving a signed JSON POST for every peer added, removed or changed. Disabled if empty")
+	pflags.String("webhook-secret", "", "secret used to sign the webhook requests, the X-Wirey-Signature header is sha256=<hex hmac-sha256 of the body>")
+	pflags.String("webhook-report", webhook.ReportLeader, "which nodes report a change to the webhook: leader (only the node with the lowest public key) or each")
+	pflags.Duration("webhook-timeout", webhook.DefaultTimeout, "timeout of every webhook request")
 	pflags.String("log-level", "info", "logging level to be used panic, fatal, error, trace, debug, warn, info")
 
 	rootCmd.MarkFlagRequired("endpoint")
@@ -244,19 +597,47 @@ func init() {
 	viper.BindPFlag("endpoint-port", pflags.Lookup("endpoint-port"))
 	viper.BindPFlag("etcd", pflags.Lookup("etcd"))
 	viper.BindPFlag("etcd-port", pflags.Lookup("etcd-port"))
+	viper.BindPFlag("etcd-timeout", pflags.Lookup("etcd-timeout"))
 	viper.BindPFlag("consul", pflags.Lookup("consul"))
 	viper.BindPFlag("consul-port", pflags.Lookup("consul-port"))
 	viper.BindPFlag("consul-address", pflags.Lookup("consul-address"))
 	viper.BindPFlag("consul-token", pflags.Lookup("consul-token"))
+	viper.BindPFlag("consul-timeout", pflags.Lookup("consul-timeout"))
 	viper.BindPFlag("http", pflags.Lookup("http"))
 	viper.BindPFlag("http-port", pflags.Lookup("http-port"))
 	viper.BindPFlag("httpbasicauth", pflags.Lookup("httpbasicauth"))
+	viper.BindPFlag("http-timeout", pflags.Lookup("http-timeout"))
+	viper.BindPFlag("backend-prefix", pflags.Lookup("backend-prefix"))
+	viper.BindPFlag("backend-legacy-prefix", pflags.Lookup("backend-legacy-prefix"))
 	viper.BindPFlag("ifname", pflags.Lookup("ifname"))
+	viper.BindPFlag("network-name", pflags.Lookup("network-name"))
 	viper.BindPFlag("ipaddr", pflags.Lookup("ipaddr"))
 	viper.BindPFlag("privatekeypath", pflags.Lookup("privatekeypath"))
 	viper.BindPFlag("peerdiscoveryttl", pflags.Lookup("peerdiscoveryttl"))
+	viper.BindPFlag("peercachepath", pflags.Lookup("peercachepath"))
 	viper.BindPFlag("discover", pflags.Lookup("discover"))
 	viper.BindPFlag("allowedips", pflags.Lookup("allowedips"))
+	viper.BindPFlag("dataplane", pflags.Lookup("dataplane"))
+	viper.BindPFlag("wireguard-go", pflags.Lookup("wireguard-go"))
+	viper.BindPFlag("dataplane-configdir", pflags.Lookup("dataplane-configdir"))
+	viper.BindPFlag("netns", pflags.Lookup("netns"))
+	viper.BindPFlag("debounce", pflags.Lookup("debounce"))
+	viper.BindPFlag("debounce-max-delay", pflags.Lookup("debounce-max-delay"))
+	viper.BindPFlag("min-apply-interval", pflags.Lookup("min-apply-interval"))
+	viper.BindPFlag("flap-threshold", pflags.Lookup("flap-threshold"))
+	viper.BindPFlag("flap-window", pflags.Lookup("flap-window"))
+	viper.BindPFlag("mtu", pflags.Lookup("mtu"))
+	viper.BindPFlag("local-overrides", pflags.Lookup("local-overrides"))
+	viper.BindPFlag("control-socket", pflags.Lookup("control-socket"))
+	viper.BindPFlag("metrics-listen", pflags.Lookup("metrics-listen"))
+	viper.BindPFlag("health-listen", pflags.Lookup("health-listen"))
+	viper.BindPFlag("health-liveness-grace", pflags.Lookup("health-liveness-grace"))
+	viper.BindPFlag("health-backend-grace", pflags.Lookup("health-backend-grace"))
+	viper.BindPFlag("hook-timeout", pflags.Lookup("hook-timeout"))
+	viper.BindPFlag("webhook-url", pflags.Lookup("webhook-url"))
+	viper.BindPFlag("webhook-secret", pflags.Lookup("webhook-secret"))
+	viper.BindPFlag("webhook-report", pflags.Lookup("webhook-report"))
+	viper.BindPFlag("webhook-timeout", pflags.Lookup("webhook-timeout"))
 	viper.BindPFlag("log-level", pflags.Lookup("log-level"))
 
 	viper.SetEnvPrefix("wirey")
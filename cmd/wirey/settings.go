@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"wirey/backend"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// settingsKeys are the daemon flags that the network settings can set
+var settingsKeys = []string{"endpoint-port", "mtu", "allowedips", "peerdiscoveryttl"}
+
+// networkLayer holds the values the network settings give to the daemon flags.
+// They are a layer above the configuration, which they leave untouched: a
+// key the settings stop setting falls back to the local value.
+var networkLayer = struct {
+	sync.RWMutex
+	values map[string]interface{}
+}{values: map[string]interface{}{}}
+
+// setting returns the value of a daemon flag, the one of the network
+// settings when they set it
+func setting(key string) interface{} {
+	networkLayer.RLock()
+	defer networkLayer.RUnlock()
+	if value, ok := networkLayer.values[key]; ok {
+		return value
+	}
+	return viper.Get(key)
+}
+
+func settingString(key string) string    { return cast.ToString(setting(key)) }
+func settingInt(key string) int          { return cast.ToInt(setting(key)) }
+func settingStrings(key string) []string { return cast.ToStringSlice(setting(key)) }
+
+var settingsCmd = &cobra.Command{
+	Use:   "settings",
+	Short: "read and write the settings shared by every node of the network",
+	Long: `The network settings are stored in the backend under --network-name, every node reads them on startup and
+reloads when they change. The document is a JSON object with some of the daemon flags:
+
+  {"endpoint-port": 51820, "mtu": 1420, "allowedips": ["10.1.0.0/16"], "peerdiscoveryttl": "30s"}
+
+A flag set on a node, on the command line or in its configuration file, only wins over the network settings
+when it is listed in the --local-overrides of that node.`,
+}
+
+var settingsGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "print the network settings stored in the backend",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		store := settingsStore()
+		s, err := store.GetSettings(context.Background(), networkName())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if s == nil {
+			s = &backend.Settings{}
+		}
+		out, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	},
+}
+
+var settingsPutCmd = &cobra.Command{
+	Use:   "put [file]",
+	Short: "replace the network settings with the JSON document in the given file or stdin",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		var in io.Reader = os.Stdin
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			in = f
+		}
+
+		// a typo in a key would silently leave the nodes on their local value
+		s := backend.Settings{}
+		d := json.NewDecoder(in)
+		d.DisallowUnknownFields()
+		if err := d.Decode(&s); err != nil {
+			log.Fatalf("unable to parse the network settings: %s", err.Error())
+		}
+		if err := s.Validate(); err != nil {
+			log.Fatal(err)
+		}
+
+		network := networkName()
+		if err := settingsStore().PutSettings(context.Background(), network, s); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("settings of %s updated\n", network)
+	},
+}
+
+// settingsStore returns the configured backend, when it can store settings
+func settingsStore() backend.SettingsStore {
+	b, err := backendFactory()
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, ok := b.(backend.SettingsStore)
+	if !ok {
+		log.Fatal("the backend cannot store the network settings")
+	}
+	return store
+}
+
+// loadSettings reads the settings of network and applies them, it returns
+// nil when the backend has none or cannot store them
+func loadSettings(ctx context.Context, b backend.Backend, network string, flags *pflag.FlagSet) (*backend.Settings, error) {
+	store, ok := b.(backend.SettingsStore)
+	if !ok {
+		return nil, nil
+	}
+	s, err := store.GetSettings(ctx, network)
+	if err != nil {
+		return nil, err
+	}
+	return s, applySettings(s, flags)
+}
+
+// applySettings makes the daemon flags follow the network settings s. A
+// flag set locally only wins when it is listed in --local-overrides. When
+// s is not valid nothing changes.
+func applySettings(s *backend.Settings, flags *pflag.FlagSet) error {
+	values := map[string]interface{}{}
+	if s != nil {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+		// the json names of the settings are the flag names
+		j, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(j, &values); err != nil {
+			return err
+		}
+	}
+
+	overrides := map[string]bool{}
+	for _, key := range viper.GetStringSlice("local-overrides") {
+		overrides[key] = true
+	}
+
+	networkLayer.Lock()
+	defer networkLayer.Unlock()
+	layer := map[string]interface{}{}
+	for _, key := range settingsKeys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		local := setLocally(key, flags)
+		if local && overrides[key] {
+			log.Debugf("the local %s overrides the network settings", key)
+			continue
+		}
+		if _, replaced := networkLayer.values[key]; local && !replaced {
+			log.Warnf("the local %s is replaced by the network settings, add it to --local-overrides to keep it", key)
+		}
+		layer[key] = value
+	}
+	networkLayer.values = layer
+	return nil
+}
+
+// setLocally tells if the flag is set on the command line or in the
+// configuration file
+func setLocally(key string, flags *pflag.FlagSet) bool {
+	if f := flags.Lookup(key); f != nil && f.Changed {
+		return true
+	}
+	return viper.InConfig(key)
+}
+
+// joinSettings reads the network settings and applies them to i, before it
+// registers its peer. Only the errors of the backend are returned, to be
+// retried: invalid settings keep the previous ones. It returns the settings
+// read.
+func joinSettings(ctx context.Context, i *backend.Interface, store backend.SettingsStore, flags *pflag.FlagSet) (*backend.Settings, error) {
+	s, err := store.GetSettings(ctx, i.Network)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySettings(s, flags); err != nil {
+		log.Warnf("Unable to apply the network settings, keeping the previous ones: %s", err.Error())
+		return s, nil
+	}
+	return s, updateInterface(i)
+}
+
+// watchSettings reloads i every time the network settings differ from last,
+// the ones it joined with. A reload that fails is tried again on the next
+// check.
+func watchSettings(ctx context.Context, i *backend.Interface, store backend.SettingsStore, interval time.Duration, last *backend.Settings) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		s, err := store.GetSettings(ctx, i.Network)
+		if err != nil {
+			log.Debugf("unable to read the network settings: %s", err.Error())
+			continue
+		}
+		if reflect.DeepEqual(s, last) {
+			continue
+		}
+		log.Infoln("The network settings changed, reloading")
+		if err := i.Reload(ctx); err != nil {
+			if ctx.Err() == nil {
+				log.Errorf("unable to apply the network settings: %s", err.Error())
+			}
+			continue
+		}
+		last = s
+	}
+}
+
+func init() {
+	settingsCmd.AddCommand(settingsGetCmd)
+	settingsCmd.AddCommand(settingsPutCmd)
+	rootCmd.AddCommand(settingsCmd)
+}
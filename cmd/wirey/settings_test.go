@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"wirey/backend"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplySettingsFallsBackToTheLocalValue(t *testing.T) {
+	defer viper.Reset()
+	flags := pflag.NewFlagSet("wirey", pflag.ContinueOnError)
+	flags.Int("mtu", 0, "")
+	flags.String("endpoint-port", "2345", "")
+	require.NoError(t, viper.BindPFlags(flags))
+	require.NoError(t, flags.Set("mtu", "1400"))
+
+	require.NoError(t, applySettings(&backend.Settings{MTU: 1420, EndpointPort: 51820}, flags))
+	assert.Equal(t, 1420, settingInt("mtu"))
+	assert.Equal(t, "51820", settingString("endpoint-port"))
+	assert.Equal(t, 1400, viper.GetInt("mtu"), "the configuration is left untouched")
+
+	// the local values are back once the settings stop setting them
+	require.NoError(t, applySettings(nil, flags))
+	assert.Equal(t, 1400, settingInt("mtu"))
+	assert.Equal(t, "2345", settingString("endpoint-port"))
+
+	viper.Set("local-overrides", []string{"mtu"})
+	require.NoError(t, applySettings(&backend.Settings{MTU: 1420}, flags))
+	assert.Equal(t, 1400, settingInt("mtu"))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"wirey/backend"
+	"wirey/pkg/control"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show whether the tunnels with the peers in the backend work",
+	Long: `Merge the peers in the backend with the state of the wireguard device: latest handshake, transferred bytes,
+the endpoint in use and the peers that are only in the backend or only on the device.
+
+When the daemon is running, its state is shown too, see wirey daemon status.
+
+The exit code is 1 when the device does not exist, the daemon reports a degraded state or an unreachable backend,
+or a peer is unhealthy: only in the backend, only on the device, or with a handshake older than --stale-after.
+A peer that never completed a handshake is reported as no-handshake and does not fail, wireguard only starts one when
+there is traffic. Static peers, like phones, are not expected to be always connected, a stale handshake does not make
+them unhealthy. Use it as a health probe.
+
+The device is read through --dataplane, in the --netns of the daemon.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+
+		format, _ := cmd.Flags().GetString("format")
+		staleAfter, _ := cmd.Flags().GetDuration("stale-after")
+		ifname := viper.GetString("ifname")
+
+		device, err := showDevice(ifname)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		statuses := backend.PeerStatuses(backendPeers(), device, staleAfter, time.Now())
+
+		// the daemon, when running, knows if the backend and the reconcile work
+		var daemon *control.Status
+		if client := runningDaemon(); client != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			daemon, _ = client.Status(ctx)
+			cancel()
+		}
+
+		switch format {
+		case peersFormatJSON:
+			err = printJSON(os.Stdout, struct {
+				Interface string               `json:"interface"`
+				Device    bool                 `json:"device"`
+				Daemon    *control.Status      `json:"daemon,omitempty"`
+				Peers     []backend.PeerStatus `json:"peers"`
+			}{ifname, device != nil, daemon, statuses})
+		case peersFormatTable:
+			if daemon != nil {
+				printDaemonSummary(os.Stdout, daemon)
+			}
+			if device == nil {
+				fmt.Printf("the device %s does not exist\n", ifname)
+			}
+			err = printStatuses(os.Stdout, statuses, time.Now())
+		default:
+			err = fmt.Errorf("unknown format %q, available formats: [%s, %s]", format, peersFormatTable, peersFormatJSON)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		healthy := device != nil
+		if daemon != nil {
+			healthy = healthy && daemon.Backend.Healthy &&
+				daemon.State != backend.StateDegraded.String() && daemon.State != backend.StateJoining.String()
+		}
+		for _, s := range statuses {
+			healthy = healthy && s.Healthy()
+		}
+		if !healthy {
+			os.Exit(1)
+		}
+	},
+}
+
+func printStatuses(w io.Writer, statuses []backend.PeerStatus, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PUBLIC KEY\tNAME\tIP\tENDPOINT\tALLOWED IPS\tHANDSHAKE\tRX\tTX\tHEALTH")
+	for _, s := range statuses {
+		handshake := "never"
+		if s.LatestHandshake != nil {
+			handshake = fmt.Sprintf("%s ago", now.Sub(*s.LatestHandshake).Truncate(time.Second))
+		}
+		health := string(s.Health)
+		if s.Static {
+			health += " (static)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			s.PublicKey, dash(s.Name), dash(s.IP), dash(s.Endpoint), dash(strings.Join(s.AllowedIPs, ",")),
+			handshake, s.TransferRx, s.TransferTx, health)
+	}
+	return tw.Flush()
+}
+
+func init() {
+	statusCmd.Flags().String("format", peersFormatTable, "the output format: table or json")
+	statusCmd.Flags().Duration("stale-after", 5*time.Minute, "a peer whose latest handshake is older than this is unhealthy, wireguard renews handshakes every 2 minutes while there is traffic")
+	rootCmd.AddCommand(statusCmd)
+}
@@ -4,7 +4,6 @@ import (
 	"crypto/subtle"
 	"encoding/json"
 	"log"
-	"net"
 	"sync"
 
 	"github.com/gorilla/mux"
@@ -12,55 +11,86 @@ import (
 	"net/http"
 )
 
-type Peer struct {
-	PublicKey []byte
-	Endpoint  string
-	IP        *net.IP
+// Store keeps the peers and the settings, as sent by wirey, per interface name
+type Store struct {
+	store    map[string]map[string]json.RawMessage
+	settings map[string]json.RawMessage
+	mutex    *sync.RWMutex
 }
 
-type Store struct {
-	store map[string]Peer
-	mutex *sync.RWMutex
+func NewStore() *Store {
+	return &Store{
+		mutex:    &sync.RWMutex{},
+		store:    map[string]map[string]json.RawMessage{},
+		settings: map[string]json.RawMessage{},
+	}
 }
 
-func (s *Store) write(key string, val Peer) {
+func (s *Store) write(ifname, key string, val json.RawMessage) {
 	s.mutex.Lock()
-	s.store[key] = val
+	if _, ok := s.store[ifname]; !ok {
+		s.store[ifname] = map[string]json.RawMessage{}
+	}
+	s.store[ifname][key] = val
 	s.mutex.Unlock()
 }
 
-func (s *Store) read() map[string]Peer {
+func (s *Store) delete(ifname, key string) {
+	s.mutex.Lock()
+	delete(s.store[ifname], key)
+	s.mutex.Unlock()
+}
+
+func (s *Store) read(ifname string) []json.RawMessage {
 	s.mutex.RLock()
-	res := s.store
+	res := []json.RawMessage{}
+	for _, v := range s.store[ifname] {
+		res = append(res, v)
+	}
 	s.mutex.RUnlock()
 	return res
 }
 
+func (s *Store) writeSettings(ifname string, val json.RawMessage) {
+	s.mutex.Lock()
+	s.settings[ifname] = val
+	s.mutex.Unlock()
+}
+
+func (s *Store) readSettings(ifname string) (json.RawMessage, bool) {
+	s.mutex.RLock()
+	val, ok := s.settings[ifname]
+	s.mutex.RUnlock()
+	return val, ok
+}
+
 func joinHandler(s *Store) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sha := mux.Vars(r)["publickeysha"]
+		vars := mux.Vars(r)
 		d := json.NewDecoder(r.Body)
 
-		peer := Peer{}
+		peer := json.RawMessage{}
 		err := d.Decode(&peer)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		s.write(sha, peer)
+		s.write(vars["ifname"], vars["publickeysha"], peer)
 		w.WriteHeader(http.StatusCreated)
 	}
 }
 
-func getPeersHandler(s *Store) func(http.ResponseWriter, *http.Request) {
+func leaveHandler(s *Store) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		s.delete(vars["ifname"], vars["publickeysha"])
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
 
-		result := s.read()
-
-		list := []Peer{}
-		for _, v := range result {
-			list = append(list, v)
-		}
+func getPeersHandler(s *Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list := s.read(mux.Vars(r)["ifname"])
 
 		resBody, err := json.Marshal(list)
 
@@ -74,6 +104,34 @@ func getPeersHandler(s *Store) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+func putSettingsHandler(s *Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := json.NewDecoder(r.Body)
+
+		settings := json.RawMessage{}
+		err := d.Decode(&settings)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.writeSettings(mux.Vars(r)["ifname"], settings)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getSettingsHandler(s *Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		settings, ok := s.readSettings(mux.Vars(r)["ifname"])
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(settings)
+	}
+}
+
 func basicAuthMiddleware(handler http.HandlerFunc, username, password string) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -88,31 +146,57 @@ func basicAuthMiddleware(handler http.HandlerFunc, username, password string) ht
 	}
 }
 
-func main() {
-	// just an ephemeral store for this example
-	store := &Store{
-		mutex: &sync.RWMutex{},
-		store: map[string]Peer{},
-	}
-
-	username := "time"
-	password := "series"
+func newRouter(store *Store, username, password string) *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc(
-		"/{ifname}/{publickeysha}",
+		"/v1/{ifname}/_config",
+		basicAuthMiddleware(
+			putSettingsHandler(store),
+			username,
+			password,
+		),
+	).Methods("PUT")
+	r.HandleFunc(
+		"/v1/{ifname}/_config",
+		basicAuthMiddleware(
+			getSettingsHandler(store),
+			username,
+			password,
+		),
+	).Methods("GET")
+	r.HandleFunc(
+		"/v1/{ifname}/peers/{publickeysha}",
 		basicAuthMiddleware(
 			joinHandler(store),
 			username,
 			password,
 		),
 	).Methods("POST")
-	r.HandleFunc("/{ifname}",
+	r.HandleFunc(
+		"/v1/{ifname}/peers/{publickeysha}",
+		basicAuthMiddleware(
+			leaveHandler(store),
+			username,
+			password,
+		),
+	).Methods("DELETE")
+	r.HandleFunc("/v1/{ifname}/peers",
 		basicAuthMiddleware(
 			getPeersHandler(store),
 			username,
 			password,
 		),
 	).Methods("GET")
+	return r
+}
+
+func main() {
+	// just an ephemeral store for this example
+	store := NewStore()
+
+	username := "time"
+	password := "series"
+	r := newRouter(store, username, password)
 
 	log.Fatal(http.ListenAndServe("0.0.0.0:8080", r))
 }
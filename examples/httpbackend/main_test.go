@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"wirey/backend"
+	"wirey/backend/backendtest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPBackend(t *testing.T) {
+	server := httptest.NewServer(newRouter(NewStore(), "time", "series"))
+	defer server.Close()
+
+	b, err := backend.NewHTTPBackend(server.URL, "test", 5*time.Second)
+	require.NoError(t, err)
+	b.BasicAuth = &backend.BasicAuth{
+		Username: "time",
+		Password: "series",
+	}
+
+	backendtest.Run(t, b)
+}
+
+func TestHTTPBackendPrefix(t *testing.T) {
+	router := newRouter(NewStore(), "time", "series")
+	server := httptest.NewServer(http.StripPrefix("/team-a", router))
+	defer server.Close()
+
+	b, err := backend.NewHTTPBackend(server.URL, "test", 5*time.Second)
+	require.NoError(t, err)
+	b.Prefix = "team-a"
+	b.BasicAuth = &backend.BasicAuth{
+		Username: "time",
+		Password: "series",
+	}
+
+	backendtest.Run(t, b)
+}
@@ -0,0 +1,84 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+// Client talks to the control API of a running daemon
+type Client struct {
+	Path   string
+	client *http.Client
+}
+
+// NewClient creates a client for the socket at path
+func NewClient(path string) *Client {
+	return &Client{
+		Path: path,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// Available tells if a daemon is listening on the socket
+func (c *Client) Available() bool {
+	if _, err := os.Stat(c.Path); err != nil {
+		return false
+	}
+	conn, err := net.Dial("unix", c.Path)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Status returns the state of the daemon
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	status := &Status{}
+	if err := c.do(ctx, http.MethodGet, statusPath, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Command runs one of the backend.Command* commands and returns the state
+// of the daemon after it
+func (c *Client) Command(ctx context.Context, name string) (*Status, error) {
+	status := &Status{}
+	if err := c.do(ctx, http.MethodPost, commandsPath+name, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, v interface{}) error {
+	// the host is ignored, every connection goes to the socket
+	req, err := http.NewRequest(method, "http://wirey"+path, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("unable to reach the daemon on %s: %s", c.Path, err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		e := errorResponse{}
+		if err := json.NewDecoder(res.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("the daemon answered with status code %d", res.StatusCode)
+		}
+		return fmt.Errorf("%s", e.Error)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
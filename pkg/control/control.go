@@ -0,0 +1,93 @@
+// Package control exposes a running backend.Interface over a local HTTP/JSON
+// API on a unix socket, and provides the client used by the cli.
+package control
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"wirey/backend"
+)
+
+// DefaultSocketDir is where the sockets are created, one per interface
+const DefaultSocketDir = "/run/wirey"
+
+// API routes
+const (
+	statusPath   = "/v1/status"
+	commandsPath = "/v1/commands/"
+)
+
+// SocketPath returns the default socket of the daemon managing ifname
+func SocketPath(ifname string) string {
+	return filepath.Join(DefaultSocketDir, ifname+".sock")
+}
+
+// Status is the state of the daemon
+type Status struct {
+	Interface string `json:"interface"`
+	State     string `json:"state"`
+	Error     string `json:"error,omitempty"`
+	// Joined tells if the local peer is in the backend
+	Joined bool `json:"joined"`
+	// LastSync is the last time the peers were fetched from the backend
+	LastSync *time.Time    `json:"lastSync,omitempty"`
+	Backend  BackendStatus `json:"backend"`
+	// Peers are the peers applied to the device
+	Peers []Peer `json:"peers"`
+}
+
+// BackendStatus tells if the backend answered the last call
+type BackendStatus struct {
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Peer is a peer applied to the device
+type Peer struct {
+	PublicKey  string   `json:"publicKey"`
+	Name       string   `json:"name,omitempty"`
+	IP         string   `json:"ip,omitempty"`
+	Endpoint   string   `json:"endpoint"`
+	AllowedIPs []string `json:"allowedIPs"`
+	Static     bool     `json:"static"`
+}
+
+// NewStatus converts the status of an interface
+func NewStatus(ifname string, s backend.Status) Status {
+	status := Status{
+		Interface: ifname,
+		State:     s.State.String(),
+		Joined:    s.Joined,
+		Backend:   BackendStatus{Healthy: s.BackendError == nil},
+		Peers:     []Peer{},
+	}
+	if s.LastError != nil {
+		status.Error = s.LastError.Error()
+	}
+	if !s.LastSync.IsZero() {
+		lastSync := s.LastSync
+		status.LastSync = &lastSync
+	}
+	if s.BackendError != nil {
+		status.Backend.Error = s.BackendError.Error()
+	}
+	for _, p := range s.Applied {
+		peer := Peer{
+			PublicKey:  strings.TrimSpace(string(p.PublicKey)),
+			Name:       p.Name,
+			Endpoint:   p.Endpoint,
+			AllowedIPs: p.AllowedIPs,
+			Static:     p.Static,
+		}
+		if peer.AllowedIPs == nil {
+			peer.AllowedIPs = []string{}
+		}
+		if p.IP != nil {
+			peer.IP = p.IP.String()
+		}
+		status.Peers = append(status.Peers, peer)
+	}
+	return status
+}
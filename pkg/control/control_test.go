@@ -0,0 +1,109 @@
+package control
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wirey/backend"
+	"wirey/pkg/wireguard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nopDataplane accepts every configuration
+type nopDataplane struct{}
+
+func (nopDataplane) LinkAdd(name string) error                               { return nil }
+func (nopDataplane) AddrAdd(name string, cidr string) error                  { return nil }
+func (nopDataplane) SetConf(name string, conf wireguard.Configuration) error { return nil }
+func (nopDataplane) LinkUp(name string) error                                { return nil }
+
+func testPeer(n byte, name string) backend.Peer {
+	ip := net.IPv4(10, 0, 0, n)
+	return backend.Peer{
+		PublicKey: []byte(name + "-public-key\n"),
+		Endpoint:  "192.168.0.1:2345",
+		IP:        &ip,
+		Name:      name,
+	}
+}
+
+func TestControlAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wirey-control")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b := backend.NewMemoryBackend()
+	require.NoError(t, b.Join(context.Background(), "wg0", testPeer(2, "other")))
+	i := &backend.Interface{
+		Backend:      b,
+		Name:         "wg0",
+		PeerCheckTTL: time.Hour,
+		LocalPeer:    testPeer(1, "local"),
+		Dataplane:    nopDataplane{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go i.Connect(ctx)
+
+	path := filepath.Join(dir, "wg0.sock")
+	served := make(chan error, 1)
+	go func() {
+		served <- NewServer(path, i).ListenAndServe(ctx)
+	}()
+
+	client := NewClient(path)
+	require.Eventually(t, client.Available, 5*time.Second, 5*time.Millisecond)
+
+	reqCtx, reqCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer reqCancel()
+
+	var status *Status
+	require.Eventually(t, func() bool {
+		status, err = client.Status(reqCtx)
+		return err == nil && status.State == backend.StateApplied.String()
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, "wg0", status.Interface)
+	assert.True(t, status.Joined)
+	assert.True(t, status.Backend.Healthy)
+	assert.NotNil(t, status.LastSync)
+	assert.Equal(t, []Peer{{
+		PublicKey:  "other-public-key",
+		Name:       "other",
+		IP:         "10.0.0.2",
+		Endpoint:   "192.168.0.1:2345",
+		AllowedIPs: []string{},
+	}}, status.Peers)
+
+	status, err = client.Command(reqCtx, backend.CommandLeave)
+	require.NoError(t, err)
+	assert.False(t, status.Joined)
+
+	status, err = client.Command(reqCtx, backend.CommandRejoin)
+	require.NoError(t, err)
+	assert.True(t, status.Joined)
+
+	_, err = client.Command(reqCtx, backend.CommandReload)
+	assert.Error(t, err, "the interface has no OnReload")
+	_, err = client.Command(reqCtx, "explode")
+	assert.Error(t, err)
+
+	// a second daemon cannot take over the socket
+	assert.Error(t, NewServer(path, i).ListenAndServe(ctx))
+
+	cancel()
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not stop")
+	}
+	assert.False(t, client.Available())
+}
@@ -0,0 +1,130 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"wirey/backend"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Server serves the control API of an interface
+type Server struct {
+	Path      string
+	Interface *backend.Interface
+}
+
+// NewServer creates a server listening on the unix socket at path
+func NewServer(path string, i *backend.Interface) *Server {
+	return &Server{
+		Path:      path,
+		Interface: i,
+	}
+}
+
+// ListenAndServe serves the API until the context is cancelled. The socket
+// is only accessible by the user running the daemon.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+	if err := removeStaleSocket(s.Path); err != nil {
+		return err
+	}
+
+	l, err := net.Listen("unix", s.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(s.Path, 0600); err != nil {
+		l.Close()
+		return err
+	}
+
+	srv := &http.Server{Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Infof("Control api listening on %s", s.Path)
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// removeStaleSocket removes the socket left by a daemon that did not stop
+// cleanly, and fails if another daemon is still listening on it
+func removeStaleSocket(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("another daemon is listening on %s", path)
+	}
+	return os.Remove(path)
+}
+
+// Handler returns the http handler of the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusPath, s.status)
+	mux.HandleFunc(commandsPath, s.command)
+	return mux
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, NewStatus(s.Interface.Name, s.Interface.Status()))
+}
+
+func (s *Server) command(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, commandsPath)
+	switch name {
+	case backend.CommandResync, backend.CommandRejoin, backend.CommandLeave, backend.CommandReload:
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown command %q", name))
+		return
+	}
+
+	if err := s.Interface.Command(r.Context(), name); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, NewStatus(s.Interface.Name, s.Interface.Status()))
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,84 @@
+package dataplane
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"wirey/pkg/wireguard"
+)
+
+const (
+	// DefaultConfigDir is where wg-quick and friends look for configurations
+	DefaultConfigDir = "/etc/wireguard"
+)
+
+// ConfigFile only writes the wireguard configuration to <Dir>/<name>.conf,
+// for hosts where another tool owns the interface (e.g. `wg syncconf`
+// triggered by a path unit). The link and its address are left untouched.
+type ConfigFile struct {
+	Dir string
+}
+
+// NewConfigFile ...
+func NewConfigFile(dir string) *ConfigFile {
+	if dir == "" {
+		dir = DefaultConfigDir
+	}
+	return &ConfigFile{
+		Dir: dir,
+	}
+}
+
+// Path returns the configuration file written for the link
+func (c *ConfigFile) Path(name string) string {
+	return filepath.Join(c.Dir, name+".conf")
+}
+
+// LinkAdd ...
+func (c *ConfigFile) LinkAdd(name string) error {
+	return nil
+}
+
+// AddrAdd ...
+func (c *ConfigFile) AddrAdd(name string, cidr string) error {
+	return nil
+}
+
+// SetConf ...
+func (c *ConfigFile) SetConf(name string, conf wireguard.Configuration) error {
+	rendered, err := wireguard.RenderConfiguration(conf)
+	if err != nil {
+		return err
+	}
+
+	// swap the file atomically, readers never see a partial configuration
+	tmp, err := ioutil.TempFile(c.Dir, name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(rendered); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.Path(name))
+}
+
+// LinkUp ...
+func (c *ConfigFile) LinkUp(name string) error {
+	return nil
+}
+
+// Show reads the device the other tool brought up from the file
+func (c *ConfigFile) Show(name string) (*wireguard.Device, error) {
+	return show(name)
+}
@@ -0,0 +1,194 @@
+// Package dataplane applies the configuration computed by wirey to the
+// local wireguard device.
+package dataplane
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+
+	"wirey/pkg/wireguard"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Available drivers
+const (
+	DriverAuto       = "auto"
+	DriverKernel     = "kernel"
+	DriverUserspace  = "userspace"
+	DriverConfigFile = "configfile"
+)
+
+// Dataplane is what the plumber needs to manage a wireguard link
+type Dataplane interface {
+	// LinkAdd creates the wireguard link, replacing any existing link with the same name
+	LinkAdd(name string) error
+	// AddrAdd assigns the address, in CIDR notation, to the link
+	AddrAdd(name string, cidr string) error
+	// SetConf replaces the wireguard configuration of the link
+	SetConf(name string, conf wireguard.Configuration) error
+	// LinkUp brings the link up
+	LinkUp(name string) error
+}
+
+// DeviceReader is implemented by the dataplanes that can read back the
+// state of the device they manage
+type DeviceReader interface {
+	Show(name string) (*wireguard.Device, error)
+}
+
+// PeerUpdater is implemented by the dataplanes that can change the peers of
+// a link in place, without recreating it: the sessions of the other peers
+// are kept
+type PeerUpdater interface {
+	// UpdatePeers adds or updates the set peers and removes the peers with
+	// the remove public keys. A set peer without endpoint keeps the one the
+	// device already has
+	UpdatePeers(name string, set []wireguard.Peer, remove []string) error
+}
+
+// MTUSetter is implemented by the dataplanes that can change the mtu of a
+// link
+type MTUSetter interface {
+	LinkSetMTU(name string, mtu int) error
+}
+
+// errNoPeerUpdater is returned by the wrappers around a dataplane that
+// cannot update the peers in place
+const errNoPeerUpdater = "the dataplane cannot update the peers in place"
+
+// errNoMTUSetter is returned by the wrappers around a dataplane that cannot
+// change the mtu
+const errNoMTUSetter = "the dataplane cannot change the mtu of the link"
+
+// errNoDeviceReader is returned by the wrappers around a dataplane that
+// cannot read the device
+const errNoDeviceReader = "the dataplane cannot read the state of the device"
+
+// ErrNoDevice is returned by Show when the device does not exist
+var ErrNoDevice = errors.New("the wireguard device does not exist")
+
+// Options configures the drivers created by New
+type Options struct {
+	// WireguardGo, when set, is an external userspace implementation run
+	// instead of the embedded wireguard-go
+	WireguardGo string
+	// ConfigDir is where the configfile driver writes the configuration
+	ConfigDir string
+	// Netns, when set, is the network namespace (name or path) the link is moved to
+	Netns string
+}
+
+// New returns the dataplane for the named driver
+func New(driver string, opts Options) (Dataplane, error) {
+	var d Dataplane
+	switch driver {
+	case DriverAuto, "":
+		d = NewAuto(NewKernel(), NewUserspace(opts.WireguardGo))
+	case DriverKernel:
+		d = NewKernel()
+	case DriverUserspace:
+		d = NewUserspace(opts.WireguardGo)
+	case DriverConfigFile:
+		if opts.Netns != "" {
+			return nil, fmt.Errorf("the %s dataplane does not manage the link, it cannot be moved to a network namespace", DriverConfigFile)
+		}
+		return NewConfigFile(opts.ConfigDir), nil
+	default:
+		return nil, fmt.Errorf("unknown dataplane driver %q, available drivers: [%s, %s, %s, %s]", driver, DriverAuto, DriverKernel, DriverUserspace, DriverConfigFile)
+	}
+
+	if opts.Netns != "" {
+		return NewNamespace(d, opts.Netns)
+	}
+	return d, nil
+}
+
+// Auto uses the kernel module and falls back to the userspace
+// implementation the first time the kernel refuses to create the link.
+type Auto struct {
+	kernel    Dataplane
+	userspace Dataplane
+
+	mutex    sync.Mutex
+	selected Dataplane
+}
+
+// NewAuto ...
+func NewAuto(kernel, userspace Dataplane) *Auto {
+	return &Auto{
+		kernel:    kernel,
+		userspace: userspace,
+		selected:  kernel,
+	}
+}
+
+func (a *Auto) current() Dataplane {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.selected
+}
+
+// LinkAdd ...
+func (a *Auto) LinkAdd(name string) error {
+	err := a.current().LinkAdd(name)
+	if err == nil || a.current() != a.kernel || !kernelModuleMissing(err) {
+		return err
+	}
+
+	log.Warnf("the wireguard kernel module is not available (%s), switching to the userspace implementation", err.Error())
+	a.mutex.Lock()
+	a.selected = a.userspace
+	a.mutex.Unlock()
+	return a.userspace.LinkAdd(name)
+}
+
+// AddrAdd ...
+func (a *Auto) AddrAdd(name string, cidr string) error {
+	return a.current().AddrAdd(name, cidr)
+}
+
+// SetConf ...
+func (a *Auto) SetConf(name string, conf wireguard.Configuration) error {
+	return a.current().SetConf(name, conf)
+}
+
+// LinkUp ...
+func (a *Auto) LinkUp(name string) error {
+	return a.current().LinkUp(name)
+}
+
+// Show ...
+func (a *Auto) Show(name string) (*wireguard.Device, error) {
+	r, ok := a.current().(DeviceReader)
+	if !ok {
+		return nil, fmt.Errorf(errNoDeviceReader)
+	}
+	return r.Show(name)
+}
+
+// UpdatePeers ...
+func (a *Auto) UpdatePeers(name string, set []wireguard.Peer, remove []string) error {
+	u, ok := a.current().(PeerUpdater)
+	if !ok {
+		return fmt.Errorf(errNoPeerUpdater)
+	}
+	return u.UpdatePeers(name, set, remove)
+}
+
+// LinkSetMTU ...
+func (a *Auto) LinkSetMTU(name string, mtu int) error {
+	m, ok := a.current().(MTUSetter)
+	if !ok {
+		return fmt.Errorf(errNoMTUSetter)
+	}
+	return m.LinkSetMTU(name, mtu)
+}
+
+// kernelModuleMissing tells if the kernel refused the link type, which is
+// what happens when the wireguard module is not there.
+func kernelModuleMissing(err error) bool {
+	return errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENODEV)
+}
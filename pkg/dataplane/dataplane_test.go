@@ -0,0 +1,92 @@
+package dataplane
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"wirey/pkg/wireguard"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDataplane struct {
+	linkAddErr error
+	calls      []string
+}
+
+func (f *fakeDataplane) LinkAdd(name string) error {
+	f.calls = append(f.calls, "LinkAdd")
+	return f.linkAddErr
+}
+
+func (f *fakeDataplane) AddrAdd(name string, cidr string) error {
+	f.calls = append(f.calls, "AddrAdd")
+	return nil
+}
+
+func (f *fakeDataplane) SetConf(name string, conf wireguard.Configuration) error {
+	f.calls = append(f.calls, "SetConf")
+	return nil
+}
+
+func (f *fakeDataplane) LinkUp(name string) error {
+	f.calls = append(f.calls, "LinkUp")
+	return nil
+}
+
+func TestAutoFallsBackToUserspace(t *testing.T) {
+	kernel := &fakeDataplane{linkAddErr: syscall.EOPNOTSUPP}
+	userspace := &fakeDataplane{}
+	a := NewAuto(kernel, userspace)
+
+	assert.NoError(t, a.LinkAdd("wg0"))
+	assert.NoError(t, a.SetConf("wg0", wireguard.Configuration{}))
+	assert.NoError(t, a.LinkAdd("wg0"))
+
+	assert.Equal(t, []string{"LinkAdd"}, kernel.calls)
+	assert.Equal(t, []string{"LinkAdd", "SetConf", "LinkAdd"}, userspace.calls)
+}
+
+func TestAutoKeepsKernelOnOtherErrors(t *testing.T) {
+	kernel := &fakeDataplane{linkAddErr: fmt.Errorf("wrapped: %w", syscall.EPERM)}
+	userspace := &fakeDataplane{}
+	a := NewAuto(kernel, userspace)
+
+	assert.Error(t, a.LinkAdd("wg0"))
+	assert.NoError(t, a.LinkUp("wg0"))
+
+	assert.Equal(t, []string{"LinkAdd", "LinkUp"}, kernel.calls)
+	assert.Empty(t, userspace.calls)
+}
+
+func TestConfigFileWritesConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wirey-dataplane")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := NewConfigFile(dir)
+	conf := wireguard.Configuration{
+		Interface: wireguard.Interface{
+			ListenPort: 2345,
+			PrivateKey: "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=",
+		},
+	}
+	assert.NoError(t, c.LinkAdd("wg0"))
+	assert.NoError(t, c.SetConf("wg0", conf))
+
+	expected, _ := wireguard.RenderConfiguration(conf)
+	written, err := ioutil.ReadFile(filepath.Join(dir, "wg0.conf"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), string(written))
+}
+
+func TestShowMissingDevice(t *testing.T) {
+	_, err := NewKernel().Show("wirey-missing0")
+	assert.Equal(t, ErrNoDevice, err)
+	_, err = NewConfigFile("").Show("wirey-missing0")
+	assert.Equal(t, ErrNoDevice, err)
+}
@@ -0,0 +1,96 @@
+package dataplane
+
+import (
+	"fmt"
+
+	"wirey/pkg/wireguard"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// Kernel manages links backed by the wireguard kernel module
+type Kernel struct{}
+
+// NewKernel ...
+func NewKernel() *Kernel {
+	return &Kernel{}
+}
+
+// LinkAdd ...
+func (k *Kernel) LinkAdd(name string) error {
+	// delete any old link
+	link, _ := netlink.LinkByName(name)
+	if link != nil {
+		log.Infoln("Delete old link")
+		netlink.LinkDel(link)
+	}
+
+	return netlink.LinkAdd(&netlink.GenericLink{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: name,
+		},
+		LinkType: "wireguard",
+	})
+}
+
+// AddrAdd ...
+func (k *Kernel) AddrAdd(name string, cidr string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+
+	addr, err := netlink.ParseAddr(cidr)
+	if err != nil {
+		return fmt.Errorf("error parsing the new ip address: %s", err.Error())
+	}
+	return netlink.AddrAdd(link, addr)
+}
+
+// SetConf ...
+func (k *Kernel) SetConf(name string, conf wireguard.Configuration) error {
+	_, err := wireguard.SetConf(name, conf)
+	return err
+}
+
+// UpdatePeers ...
+func (k *Kernel) UpdatePeers(name string, set []wireguard.Peer, remove []string) error {
+	_, err := wireguard.SetPeers(name, set, remove)
+	return err
+}
+
+// Show ...
+func (k *Kernel) Show(name string) (*wireguard.Device, error) {
+	return show(name)
+}
+
+// show reads the device with the wg tool, which knows the kernel and the
+// userspace devices, or returns ErrNoDevice
+func show(name string) (*wireguard.Device, error) {
+	if _, err := netlink.LinkByName(name); err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil, ErrNoDevice
+		}
+		return nil, err
+	}
+	return wireguard.Show(name)
+}
+
+// LinkUp ...
+func (k *Kernel) LinkUp(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetUp(link)
+}
+
+// LinkSetMTU ...
+func (k *Kernel) LinkSetMTU(name string, mtu int) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetMTU(link, mtu)
+}
@@ -0,0 +1,162 @@
+package dataplane
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"wirey/pkg/wireguard"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// Namespace runs a dataplane inside another network namespace.
+//
+// The link is created in the namespace wirey runs in, so that its UDP
+// socket lives there, and then moved into the target namespace where the
+// address, the configuration and the link state are applied.
+// See https://www.wireguard.com/netns/
+type Namespace struct {
+	Dataplane
+	target netns.NsHandle
+}
+
+// NewNamespace wraps inner so that links end up in the network namespace
+// identified by name (as in `ip netns`) or by path.
+func NewNamespace(inner Dataplane, nameOrPath string) (*Namespace, error) {
+	var target netns.NsHandle
+	var err error
+	if strings.Contains(nameOrPath, "/") {
+		target, err = netns.GetFromPath(nameOrPath)
+	} else {
+		target, err = netns.GetFromName(nameOrPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the network namespace %s: %s", nameOrPath, err.Error())
+	}
+
+	return &Namespace{
+		Dataplane: inner,
+		target:    target,
+	}, nil
+}
+
+// LinkAdd ...
+func (n *Namespace) LinkAdd(name string) error {
+	handle, err := netlink.NewHandleAt(n.target)
+	if err != nil {
+		return err
+	}
+	defer handle.Delete()
+
+	// delete any old link, it lives in the target namespace
+	link, _ := handle.LinkByName(name)
+	if link != nil {
+		log.Infoln("Delete old link")
+		handle.LinkDel(link)
+	}
+
+	if err := n.Dataplane.LinkAdd(name); err != nil {
+		return err
+	}
+
+	link, err = netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkSetNsFd(link, int(n.target)); err != nil {
+		return fmt.Errorf("unable to move %s into the network namespace: %s", name, err.Error())
+	}
+	return nil
+}
+
+// AddrAdd ...
+func (n *Namespace) AddrAdd(name string, cidr string) error {
+	return n.do(func() error {
+		return n.Dataplane.AddrAdd(name, cidr)
+	})
+}
+
+// SetConf ...
+func (n *Namespace) SetConf(name string, conf wireguard.Configuration) error {
+	return n.do(func() error {
+		return n.Dataplane.SetConf(name, conf)
+	})
+}
+
+// LinkUp ...
+func (n *Namespace) LinkUp(name string) error {
+	return n.do(func() error {
+		return n.Dataplane.LinkUp(name)
+	})
+}
+
+// UpdatePeers ...
+func (n *Namespace) UpdatePeers(name string, set []wireguard.Peer, remove []string) error {
+	u, ok := n.Dataplane.(PeerUpdater)
+	if !ok {
+		return fmt.Errorf(errNoPeerUpdater)
+	}
+	return n.do(func() error {
+		return u.UpdatePeers(name, set, remove)
+	})
+}
+
+// LinkSetMTU ...
+func (n *Namespace) LinkSetMTU(name string, mtu int) error {
+	m, ok := n.Dataplane.(MTUSetter)
+	if !ok {
+		return fmt.Errorf(errNoMTUSetter)
+	}
+	return n.do(func() error {
+		return m.LinkSetMTU(name, mtu)
+	})
+}
+
+// Show reads the device from the target namespace
+func (n *Namespace) Show(name string) (*wireguard.Device, error) {
+	r, ok := n.Dataplane.(DeviceReader)
+	if !ok {
+		return nil, fmt.Errorf(errNoDeviceReader)
+	}
+	var device *wireguard.Device
+	err := n.do(func() error {
+		var err error
+		device, err = r.Show(name)
+		return err
+	})
+	return device, err
+}
+
+// do runs fn from a thread switched to the target namespace, netlink
+// sockets opened and processes started by fn inherit it.
+func (n *Namespace) do(fn func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		origin, err := netns.Get()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer origin.Close()
+
+		if err := netns.Set(n.target); err != nil {
+			errs <- fmt.Errorf("unable to enter the network namespace: %s", err.Error())
+			return
+		}
+		errs <- fn()
+
+		// a goroutine exiting while locked terminates its thread, so a thread
+		// that cannot switch back is never reused by the scheduler
+		if err := netns.Set(origin); err != nil {
+			log.Errorf("unable to leave the network namespace: %s", err.Error())
+			return
+		}
+		runtime.UnlockOSThread()
+	}()
+	return <-errs
+}
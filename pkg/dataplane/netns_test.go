@@ -0,0 +1,64 @@
+package dataplane
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// vethDataplane creates veth links, so that the namespace handling can be
+// tested without the wireguard kernel module.
+type vethDataplane struct {
+	Kernel
+}
+
+func (v *vethDataplane) LinkAdd(name string) error {
+	return netlink.LinkAdd(&netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: name,
+		},
+		PeerName: name + "p",
+	})
+}
+
+func TestNamespaceMovesTheLink(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("network namespaces require root")
+	}
+	if err := exec.Command("ip", "netns", "add", "wirey-test").Run(); err != nil {
+		t.Skipf("unable to create a network namespace: %s", err)
+	}
+	defer exec.Command("ip", "netns", "del", "wirey-test").Run()
+
+	n, err := NewNamespace(&vethDataplane{}, "wirey-test")
+	require.NoError(t, err)
+
+	require.NoError(t, n.LinkAdd("wireytest0"))
+	require.NoError(t, n.AddrAdd("wireytest0", "10.99.0.1/24"))
+	require.NoError(t, n.LinkUp("wireytest0"))
+	// recreating the link must replace the one inside the namespace
+	require.NoError(t, n.LinkAdd("wireytest0"))
+	require.NoError(t, n.AddrAdd("wireytest0", "10.99.0.1/24"))
+
+	_, err = netlink.LinkByName("wireytest0")
+	assert.Error(t, err, "the link must not be left in the current namespace")
+
+	target, err := netns.GetFromName("wirey-test")
+	require.NoError(t, err)
+	defer target.Close()
+	handle, err := netlink.NewHandleAt(target)
+	require.NoError(t, err)
+	defer handle.Delete()
+
+	link, err := handle.LinkByName("wireytest0")
+	require.NoError(t, err)
+	addrs, err := handle.AddrList(link, netlink.FAMILY_V4)
+	require.NoError(t, err)
+	require.Len(t, addrs, 1)
+	assert.Equal(t, "10.99.0.1/24", addrs[0].IPNet.String())
+}
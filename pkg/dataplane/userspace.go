@@ -0,0 +1,148 @@
+package dataplane
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/ipc"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// Userspace manages links backed by a wireguard-go TUN device, for hosts
+// without the wireguard kernel module. The device runs inside wirey and
+// serves the usual control socket, addresses and configuration are applied
+// the same way as for the kernel module.
+type Userspace struct {
+	Kernel
+	// Command, when set, is an external wireguard-go executable run instead
+	// of the embedded implementation
+	Command string
+
+	mutex   sync.Mutex
+	devices map[string]*userspaceDevice
+}
+
+// userspaceDevice is an embedded wireguard-go device and its control socket
+type userspaceDevice struct {
+	device  *device.Device
+	uapi    net.Listener
+	stopped chan struct{}
+}
+
+// NewUserspace ...
+func NewUserspace(command string) *Userspace {
+	return &Userspace{
+		Command: command,
+		devices: map[string]*userspaceDevice{},
+	}
+}
+
+// LinkAdd ...
+func (u *Userspace) LinkAdd(name string) error {
+	u.mutex.Lock()
+	old := u.devices[name]
+	u.mutex.Unlock()
+	if old != nil {
+		log.Infoln("Delete old link")
+		old.close()
+	}
+
+	// deleting the TUN device also stops the wireguard-go device owning it
+	link, _ := netlink.LinkByName(name)
+	if link != nil {
+		log.Infoln("Delete old link")
+		netlink.LinkDel(link)
+	}
+
+	if u.Command != "" {
+		return u.run(name)
+	}
+
+	tdev, err := tun.CreateTUN(name, device.DefaultMTU)
+	if err != nil {
+		return fmt.Errorf("unable to create the TUN device %s: %s", name, err.Error())
+	}
+	file, err := ipc.UAPIOpen(name)
+	if err != nil {
+		tdev.Close()
+		return fmt.Errorf("unable to open the control socket of %s: %s", name, err.Error())
+	}
+	uapi, err := ipc.UAPIListen(name, file)
+	if err != nil {
+		file.Close()
+		tdev.Close()
+		return fmt.Errorf("unable to listen on the control socket of %s: %s", name, err.Error())
+	}
+
+	logger := device.NewLogger(device.LogLevelError, fmt.Sprintf("(%s) ", name))
+	d := &userspaceDevice{
+		device:  device.NewDevice(tdev, conn.NewDefaultBind(), logger),
+		uapi:    uapi,
+		stopped: make(chan struct{}),
+	}
+	go d.serve()
+
+	u.mutex.Lock()
+	u.devices[name] = d
+	u.mutex.Unlock()
+
+	// the device stops by itself when the link is deleted, the control
+	// socket is closed only here: closing it twice closes the descriptors
+	// of the next device
+	go func() {
+		<-d.device.Wait()
+		uapi.Close()
+		u.mutex.Lock()
+		if u.devices[name] == d {
+			delete(u.devices, name)
+		}
+		u.mutex.Unlock()
+		close(d.stopped)
+	}()
+	return nil
+}
+
+// run starts the external wireguard-go, which daemonizes itself once the
+// device is ready
+func (u *Userspace) run(name string) error {
+	path, err := exec.LookPath(u.Command)
+	if err != nil {
+		return fmt.Errorf("the userspace wireguard implementation (%s) is not available: %s", u.Command, err.Error())
+	}
+
+	cmd := exec.Command(path, name)
+	cmd.Env = append(os.Environ(), "WG_I_PREFER_BUGGY_USERSPACE_TO_POLISHED_KMOD=1")
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s - %s", err.Error(), buf.String())
+	}
+	return nil
+}
+
+// serve answers the wg tools on the control socket until it is closed
+func (d *userspaceDevice) serve() {
+	for {
+		c, err := d.uapi.Accept()
+		if err != nil {
+			return
+		}
+		go d.device.IpcHandle(c)
+	}
+}
+
+// close stops the device, which deletes its link, and waits for its control
+// socket to be removed
+func (d *userspaceDevice) close() {
+	d.device.Close()
+	<-d.stopped
+}
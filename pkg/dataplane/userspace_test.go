@@ -0,0 +1,71 @@
+package dataplane
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+// uapiGet reads the configuration of the device from its control socket
+func uapiGet(name string) (string, error) {
+	c, err := net.Dial("unix", fmt.Sprintf("/var/run/wireguard/%s.sock", name))
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("get=1\n\n")); err != nil {
+		return "", err
+	}
+	out := ""
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			break
+		}
+		out += scanner.Text() + "\n"
+	}
+	return out, scanner.Err()
+}
+
+func TestUserspaceEmbeddedDevice(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("TUN devices require root")
+	}
+	if _, err := os.Stat("/dev/net/tun"); err != nil {
+		t.Skipf("TUN devices are not available: %s", err)
+	}
+
+	u := NewUserspace("")
+	require.NoError(t, u.LinkAdd("wireytest1"))
+	defer func() {
+		if link, err := netlink.LinkByName("wireytest1"); err == nil {
+			netlink.LinkDel(link)
+		}
+	}()
+	// recreating the link replaces the running device
+	require.NoError(t, u.LinkAdd("wireytest1"))
+	require.NoError(t, u.AddrAdd("wireytest1", "10.99.1.1/24"))
+	require.NoError(t, u.LinkUp("wireytest1"))
+
+	out, err := uapiGet("wireytest1")
+	require.NoError(t, err)
+	assert.Contains(t, out, "errno=0")
+
+	link, err := netlink.LinkByName("wireytest1")
+	require.NoError(t, err)
+	require.NoError(t, netlink.LinkDel(link))
+
+	// the device stops with its link
+	assert.Eventually(t, func() bool {
+		u.mutex.Lock()
+		defer u.mutex.Unlock()
+		return len(u.devices) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
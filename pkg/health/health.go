@@ -0,0 +1,79 @@
+// Package health serves the liveness and readiness probes of a
+// backend.Interface, for orchestrators like kubernetes or nomad.
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"wirey/backend"
+)
+
+// Probe routes
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// DefaultGracePeriod is how late the loop can be, or how long the backend
+// can be unreachable, before the probes fail
+const DefaultGracePeriod = 5 * time.Minute
+
+// Options tune the probes
+type Options struct {
+	// LivenessGracePeriod is added to the time the loop is expected to run again
+	LivenessGracePeriod time.Duration
+	// BackendGracePeriod is how long the backend can be unreachable before
+	// the interface is not ready
+	BackendGracePeriod time.Duration
+}
+
+// Live returns an error when the reconcile loop stopped ticking
+func Live(s backend.Status, opts Options, now time.Time) error {
+	if s.LastActivity.IsZero() {
+		return fmt.Errorf("the reconcile loop did not start")
+	}
+	deadline := s.LastActivity.Add(s.NextActivity + opts.LivenessGracePeriod)
+	if now.After(deadline) {
+		return fmt.Errorf("the reconcile loop did not run since %s", s.LastActivity.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Ready returns an error unless the local peer joined the backend, the
+// peers are applied and the link is up. An unreachable backend makes the
+// interface not ready after the grace period.
+func Ready(s backend.Status, opts Options, now time.Time) error {
+	if !s.Joined {
+		return fmt.Errorf("not joined")
+	}
+	if s.State == backend.StateJoining || !s.LinkUp {
+		return fmt.Errorf("the peers are not applied")
+	}
+	if s.BackendError != nil && now.Sub(s.BackendErrorSince) > opts.BackendGracePeriod {
+		return fmt.Errorf("the backend is unreachable since %s: %s", s.BackendErrorSince.Format(time.RFC3339), s.BackendError.Error())
+	}
+	return nil
+}
+
+// Handler serves LivenessPath and ReadinessPath, answering 200 or 503 with
+// the reason
+func Handler(i *backend.Interface, opts Options) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(LivenessPath, probe(i, opts, Live))
+	mux.HandleFunc(ReadinessPath, probe(i, opts, Ready))
+	return mux
+}
+
+func probe(i *backend.Interface, opts Options, check func(backend.Status, Options, time.Time) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := check(i.Status(), opts, time.Now()); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err.Error())
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
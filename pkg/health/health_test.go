@@ -0,0 +1,56 @@
+package health
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"wirey/backend"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var opts = Options{
+	LivenessGracePeriod: time.Minute,
+	BackendGracePeriod:  time.Minute,
+}
+
+func TestLive(t *testing.T) {
+	now := time.Now()
+
+	assert.Error(t, Live(backend.Status{}, opts, now), "not started")
+
+	s := backend.Status{LastActivity: now.Add(-time.Minute), NextActivity: 30 * time.Second}
+	assert.NoError(t, Live(s, opts, now))
+
+	s.LastActivity = now.Add(-2 * time.Minute)
+	assert.Error(t, Live(s, opts, now))
+}
+
+func TestReady(t *testing.T) {
+	now := time.Now()
+	ready := backend.Status{State: backend.StateApplied, Joined: true, LinkUp: true}
+	assert.NoError(t, Ready(ready, opts, now))
+
+	s := ready
+	s.Joined = false
+	assert.Error(t, Ready(s, opts, now))
+
+	s = ready
+	s.LinkUp = false
+	assert.Error(t, Ready(s, opts, now))
+
+	s = ready
+	s.State = backend.StateJoining
+	assert.Error(t, Ready(s, opts, now))
+
+	// a degraded interface stays ready while the backend is down for a short time
+	s = ready
+	s.State = backend.StateDegraded
+	s.BackendError = fmt.Errorf("unreachable")
+	s.BackendErrorSince = now.Add(-30 * time.Second)
+	assert.NoError(t, Ready(s, opts, now))
+
+	s.BackendErrorSince = now.Add(-2 * time.Minute)
+	assert.Error(t, Ready(s, opts, now))
+}
@@ -0,0 +1,112 @@
+// Package hooks runs commands when the link of a backend.Interface or its
+// peers change, e.g. to update firewall rules or /etc/hosts.
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"wirey/backend"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTimeout bounds every command, the reconcile loop waits for them
+const DefaultTimeout = 30 * time.Second
+
+// Shell runs the commands
+const Shell = "/bin/sh"
+
+// Hooks is a backend.Observer running shell commands for the events. Every
+// command gets the event as JSON on stdin, and WIREY_EVENT, WIREY_INTERFACE,
+// WIREY_NETWORK and, for the peer events, WIREY_PEER_PUBLIC_KEY and
+// WIREY_PEER_IP in the environment.
+type Hooks struct {
+	// Commands are the commands run for each event type, in order
+	Commands map[string][]string
+	Timeout  time.Duration
+}
+
+// New returns Hooks running commands with the default timeout
+func New(commands map[string][]string) *Hooks {
+	return &Hooks{
+		Commands: commands,
+		Timeout:  DefaultTimeout,
+	}
+}
+
+// OnEvent runs the commands of the event, a failing command is logged and
+// does not prevent the next ones from running
+func (h *Hooks) OnEvent(e backend.Event) {
+	commands := h.Commands[e.Type]
+	if len(commands) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("unable to encode the %s event: %s", e.Type, err.Error())
+		return
+	}
+
+	env := append(os.Environ(), "WIREY_EVENT="+e.Type, "WIREY_INTERFACE="+e.Interface, "WIREY_NETWORK="+e.Network)
+	if e.Peer != nil {
+		env = append(env, "WIREY_PEER_PUBLIC_KEY="+string(bytes.TrimSpace(e.Peer.PublicKey)))
+		if e.Peer.IP != nil {
+			env = append(env, "WIREY_PEER_IP="+e.Peer.IP.String())
+		}
+	}
+
+	for _, command := range commands {
+		if err := h.run(command, env, payload); err != nil {
+			log.Errorf("the %s hook %q failed: %s", e.Type, command, err.Error())
+		}
+	}
+}
+
+func (h *Hooks) run(command string, env []string, payload []byte) error {
+	cmd := exec.Command(Shell, "-c", command)
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(payload)
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	// the whole process group is killed on timeout, otherwise a child still
+	// holding the output open would keep Wait from returning
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var timeout <-chan time.Time
+	if h.Timeout > 0 {
+		timer := time.NewTimer(h.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%s - %s", err.Error(), buf.String())
+		}
+	case <-timeout:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return fmt.Errorf("timed out after %s - %s", h.Timeout, buf.String())
+	}
+	if buf.Len() > 0 {
+		log.Debugf("the hook %q printed: %s", command, buf.String())
+	}
+	return nil
+}
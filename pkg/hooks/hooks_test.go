@@ -0,0 +1,69 @@
+package hooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wirey/backend"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wirey-hooks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	ip := net.ParseIP("10.0.0.2")
+	peer := backend.Peer{PublicKey: []byte("key2\n"), IP: &ip, Endpoint: "192.168.1.2:2345"}
+	h := New(map[string][]string{
+		backend.EventPeerAdded: {
+			"exit 1",
+			"cat > " + out + " && echo $WIREY_EVENT $WIREY_INTERFACE $WIREY_PEER_PUBLIC_KEY $WIREY_PEER_IP >> " + out + ".env",
+		},
+	})
+	h.OnEvent(backend.Event{
+		Type:      backend.EventPeerAdded,
+		Interface: "wg0",
+		Peer:      &peer,
+		Peers:     []backend.Peer{peer},
+		Diff:      backend.PeerDiff{Added: []backend.Peer{peer}},
+	})
+
+	env, err := ioutil.ReadFile(out + ".env")
+	require.NoError(t, err, "a failing command does not stop the next ones")
+	assert.Equal(t, "PeerAdded wg0 key2 10.0.0.2\n", string(env))
+
+	stdin, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	var event struct {
+		Type string
+		Peer struct {
+			PublicKey string
+			IP        string
+		}
+		Diff struct {
+			Added []struct{ PublicKey string }
+		}
+	}
+	require.NoError(t, json.Unmarshal(stdin, &event))
+	assert.Equal(t, backend.EventPeerAdded, event.Type)
+	assert.Equal(t, "key2", event.Peer.PublicKey)
+	assert.Equal(t, "10.0.0.2", event.Peer.IP)
+	assert.Len(t, event.Diff.Added, 1)
+}
+
+func TestTimeout(t *testing.T) {
+	h := &Hooks{Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err := h.run("sleep 5", nil, nil)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
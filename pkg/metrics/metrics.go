@@ -0,0 +1,231 @@
+// Package metrics exports the measurements of a backend.Interface and the
+// state of its device as prometheus metrics.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"wirey/backend"
+	"wirey/pkg/wireguard"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const namespace = "wirey"
+
+// values of the result label of the reconciles
+const (
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+// values of the change label of the peer changes
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+// Metrics implements backend.Recorder
+type Metrics struct {
+	registry *prometheus.Registry
+
+	backendRequestDuration *prometheus.HistogramVec
+	backendErrors          *prometheus.CounterVec
+	reconciles             *prometheus.CounterVec
+	reconcileDuration      prometheus.Histogram
+	peersConfigured        prometheus.Gauge
+	peerSetChanges         prometheus.Counter
+	peerChanges            *prometheus.CounterVec
+	linkCreations          prometheus.Counter
+}
+
+// New creates the metrics of the ifname interface. show, when not nil, is
+// called at every scrape to export the per peer metrics of the device.
+func New(ifname string, show func(name string) (*wireguard.Device, error)) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		backendRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_request_duration_seconds",
+			Help:      "Duration of the calls to the backend, per operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		backendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_errors_total",
+			Help:      "Calls to the backend that failed, per operation.",
+		}, []string{"operation"}),
+		reconciles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconciles_total",
+			Help:      "Reconciles of the device with the backend, per result.",
+		}, []string{"result"}),
+		reconcileDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "reconcile_duration_seconds",
+			Help:      "Duration of the reconciles, retries included.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		peersConfigured: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "peers_configured",
+			Help:      "Peers configured on the device.",
+		}),
+		peerSetChanges: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "peer_set_changes_total",
+			Help:      "Times a different set of peers was applied to the device.",
+		}),
+		peerChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "peer_changes_total",
+			Help:      "Peers added to, removed from or changed on the device.",
+		}, []string{"change"}),
+		linkCreations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "link_creations_total",
+			Help:      "Times the link was created or recreated.",
+		}),
+	}
+
+	// export every series from the start, so that rates work from the first error
+	for _, op := range []string{backend.OperationJoin, backend.OperationGetPeers, backend.OperationLeave} {
+		m.backendRequestDuration.WithLabelValues(op)
+		m.backendErrors.WithLabelValues(op)
+	}
+	m.reconciles.WithLabelValues(resultSuccess)
+	m.reconciles.WithLabelValues(resultError)
+	for _, change := range []string{changeAdded, changeRemoved, changeChanged} {
+		m.peerChanges.WithLabelValues(change)
+	}
+
+	reg := prometheus.WrapRegistererWith(prometheus.Labels{"interface": ifname}, m.registry)
+	reg.MustRegister(
+		m.backendRequestDuration,
+		m.backendErrors,
+		m.reconciles,
+		m.reconcileDuration,
+		m.peersConfigured,
+		m.peerSetChanges,
+		m.peerChanges,
+		m.linkCreations,
+	)
+	if show != nil {
+		reg.MustRegister(newDeviceCollector(ifname, show))
+	}
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// BackendRequest ...
+func (m *Metrics) BackendRequest(operation string, duration time.Duration, err error) {
+	m.backendRequestDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if err != nil {
+		m.backendErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// Reconcile ...
+func (m *Metrics) Reconcile(duration time.Duration, err error) {
+	m.reconcileDuration.Observe(duration.Seconds())
+	result := resultSuccess
+	if err != nil {
+		result = resultError
+	}
+	m.reconciles.WithLabelValues(result).Inc()
+}
+
+// PeersApplied ...
+func (m *Metrics) PeersApplied(count int, diff backend.PeerDiff) {
+	m.peersConfigured.Set(float64(count))
+	if diff.Empty() {
+		return
+	}
+	m.peerSetChanges.Inc()
+	m.peerChanges.WithLabelValues(changeAdded).Add(float64(len(diff.Added)))
+	m.peerChanges.WithLabelValues(changeRemoved).Add(float64(len(diff.Removed)))
+	m.peerChanges.WithLabelValues(changeChanged).Add(float64(len(diff.Changed)))
+}
+
+// LinkCreated ...
+func (m *Metrics) LinkCreated() {
+	m.linkCreations.Inc()
+}
+
+// Handler returns the http handler serving the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// deviceCollector reads the device at every scrape
+type deviceCollector struct {
+	ifname string
+	show   func(name string) (*wireguard.Device, error)
+
+	handshakeAge *prometheus.Desc
+	receive      *prometheus.Desc
+	transmit     *prometheus.Desc
+	up           *prometheus.Desc
+}
+
+func newDeviceCollector(ifname string, show func(name string) (*wireguard.Device, error)) *deviceCollector {
+	return &deviceCollector{
+		ifname: ifname,
+		show:   show,
+		handshakeAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "peer", "latest_handshake_age_seconds"),
+			"Seconds since the latest handshake with the peer, missing if there was none.",
+			[]string{"public_key"}, nil,
+		),
+		receive: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "peer", "receive_bytes_total"),
+			"Bytes received from the peer.",
+			[]string{"public_key"}, nil,
+		),
+		transmit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "peer", "transmit_bytes_total"),
+			"Bytes sent to the peer.",
+			[]string{"public_key"}, nil,
+		),
+		up: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "device", "up"),
+			"Whether the device could be read.",
+			nil, nil,
+		),
+	}
+}
+
+// Describe ...
+func (c *deviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.handshakeAge
+	ch <- c.receive
+	ch <- c.transmit
+	ch <- c.up
+}
+
+// Collect ...
+func (c *deviceCollector) Collect(ch chan<- prometheus.Metric) {
+	device, err := c.show(c.ifname)
+	if err != nil {
+		log.Debugf("unable to read the device for the metrics: %s", err.Error())
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 1)
+
+	now := time.Now()
+	for _, p := range device.Peers {
+		if !p.LatestHandshake.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.handshakeAge, prometheus.GaugeValue, now.Sub(p.LatestHandshake).Seconds(), p.PublicKey)
+		}
+		ch <- prometheus.MustNewConstMetric(c.receive, prometheus.CounterValue, float64(p.TransferRx), p.PublicKey)
+		ch <- prometheus.MustNewConstMetric(c.transmit, prometheus.CounterValue, float64(p.TransferTx), p.PublicKey)
+	}
+}
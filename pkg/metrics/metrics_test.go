@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"wirey/backend"
+	"wirey/pkg/wireguard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	m := New("wg0", nil)
+	m.BackendRequest("GetPeers", 10*time.Millisecond, nil)
+	m.BackendRequest("GetPeers", 10*time.Millisecond, fmt.Errorf("unreachable"))
+	m.BackendRequest("Join", 10*time.Millisecond, nil)
+	m.Reconcile(time.Second, nil)
+	m.Reconcile(time.Second, fmt.Errorf("unreachable"))
+	m.PeersApplied(3, backend.PeerDiff{Added: make([]backend.Peer, 2), Changed: make([]backend.PeerChange, 1)})
+	m.PeersApplied(3, backend.PeerDiff{})
+	m.LinkCreated()
+
+	body := scrape(t, m)
+	for _, line := range []string{
+		`wirey_backend_request_duration_seconds_count{interface="wg0",operation="GetPeers"} 2`,
+		`wirey_backend_request_duration_seconds_count{interface="wg0",operation="Join"} 1`,
+		`wirey_backend_errors_total{interface="wg0",operation="GetPeers"} 1`,
+		`wirey_reconciles_total{interface="wg0",result="error"} 1`,
+		`wirey_reconciles_total{interface="wg0",result="success"} 1`,
+		`wirey_reconcile_duration_seconds_count{interface="wg0"} 2`,
+		`wirey_peers_configured{interface="wg0"} 3`,
+		`wirey_peer_set_changes_total{interface="wg0"} 1`,
+		`wirey_peer_changes_total{change="added",interface="wg0"} 2`,
+		`wirey_peer_changes_total{change="changed",interface="wg0"} 1`,
+		`wirey_peer_changes_total{change="removed",interface="wg0"} 0`,
+		`wirey_link_creations_total{interface="wg0"} 1`,
+	} {
+		assert.Contains(t, body, line)
+	}
+	assert.NotContains(t, body, "wirey_device_up")
+}
+
+func TestDeviceMetrics(t *testing.T) {
+	device := &wireguard.Device{
+		Peers: []wireguard.DevicePeer{
+			{PublicKey: "peer-2", LatestHandshake: time.Now().Add(-time.Minute), TransferRx: 820, TransferTx: 764},
+			{PublicKey: "peer-3"},
+		},
+	}
+	var err error
+	m := New("wg0", func(name string) (*wireguard.Device, error) {
+		assert.Equal(t, "wg0", name)
+		return device, err
+	})
+
+	body := scrape(t, m)
+	for _, line := range []string{
+		`wirey_device_up{interface="wg0"} 1`,
+		`wirey_peer_latest_handshake_age_seconds{interface="wg0",public_key="peer-2"} 6`,
+		`wirey_peer_receive_bytes_total{interface="wg0",public_key="peer-2"} 820`,
+		`wirey_peer_transmit_bytes_total{interface="wg0",public_key="peer-2"} 764`,
+		`wirey_peer_receive_bytes_total{interface="wg0",public_key="peer-3"} 0`,
+	} {
+		assert.Contains(t, body, line)
+	}
+	// no handshake, no age
+	assert.NotContains(t, body, `wirey_peer_latest_handshake_age_seconds{interface="wg0",public_key="peer-3"}`)
+
+	err = fmt.Errorf("no such device")
+	assert.Contains(t, scrape(t, m), `wirey_device_up{interface="wg0"} 0`)
+}
+
+func TestMetricsStartAtZero(t *testing.T) {
+	body := scrape(t, New("wg0", nil))
+	assert.Contains(t, body, `wirey_backend_errors_total{interface="wg0",operation="Leave"} 0`)
+	assert.Contains(t, body, `wirey_reconciles_total{interface="wg0",result="error"} 0`)
+}
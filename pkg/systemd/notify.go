@@ -0,0 +1,115 @@
+// Package systemd reports the state of a backend.Interface to systemd with
+// sd_notify, for units of Type=notify.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"wirey/backend"
+	"wirey/pkg/health"
+
+	"github.com/coreos/go-systemd/daemon"
+	log "github.com/sirupsen/logrus"
+)
+
+// pollInterval is how often the interface is checked when the watchdog is
+// disabled or slower
+const pollInterval = time.Second
+
+// Notifier sends READY=1 once the peers are applied the first time, STATUS=
+// when the state of the interface changes and WATCHDOG=1 while the
+// reconcile loop keeps ticking
+type Notifier struct {
+	Interface *backend.Interface
+	// Watchdog is WatchdogSec of the unit, zero when disabled
+	Watchdog time.Duration
+
+	notify func(state string) error
+	ready  bool
+	status string
+}
+
+// NewNotifier returns a Notifier for i, or nil when wirey is not started by
+// systemd with NotifyAccess
+func NewNotifier(i *backend.Interface) (*Notifier, error) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return nil, nil
+	}
+	watchdog, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the systemd watchdog settings: %s", err.Error())
+	}
+	return &Notifier{
+		Interface: i,
+		Watchdog:  watchdog,
+		notify: func(state string) error {
+			_, err := daemon.SdNotify(false, state)
+			return err
+		},
+	}, nil
+}
+
+// Run notifies systemd until the context is cancelled, then sends STOPPING=1
+func (n *Notifier) Run(ctx context.Context) {
+	interval := pollInterval
+	if n.Watchdog > 0 && n.Watchdog/2 < interval {
+		interval = n.Watchdog / 2
+	}
+	if n.Watchdog > 0 {
+		log.Infof("systemd watchdog enabled, the reconcile loop must tick every %s", n.Watchdog)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, state := range n.update(n.Interface.Status(), time.Now()) {
+			if err := n.notify(state); err != nil {
+				log.Warnf("unable to notify systemd: %s", err.Error())
+			}
+		}
+		select {
+		case <-ctx.Done():
+			n.notify(daemon.SdNotifyStopping)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update returns the states to send for s. The watchdog is only fed while
+// the reconcile loop is live, it is allowed to be late by one watchdog
+// interval on top of its own schedule.
+func (n *Notifier) update(s backend.Status, now time.Time) []string {
+	var states []string
+	if !n.ready && s.LinkUp {
+		n.ready = true
+		states = append(states, daemon.SdNotifyReady)
+	}
+	if status := statusText(s); status != n.status {
+		n.status = status
+		states = append(states, "STATUS="+status)
+	}
+	if n.Watchdog > 0 {
+		if err := health.Live(s, health.Options{LivenessGracePeriod: n.Watchdog}, now); err == nil {
+			states = append(states, daemon.SdNotifyWatchdog)
+		} else if !s.LastActivity.IsZero() {
+			log.Warnf("not feeding the systemd watchdog: %s", err.Error())
+		}
+	}
+	return states
+}
+
+// statusText is the one line shown by systemctl status
+func statusText(s backend.Status) string {
+	if s.State == backend.StateJoining {
+		return "joining the backend"
+	}
+	text := fmt.Sprintf("%s, %d peers applied", s.State, len(s.Applied))
+	if s.BackendError != nil {
+		text += fmt.Sprintf(", backend unreachable since %s", s.BackendErrorSince.Format(time.RFC3339))
+	}
+	return text
+}
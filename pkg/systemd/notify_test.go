@@ -0,0 +1,33 @@
+package systemd
+
+import (
+	"testing"
+	"time"
+
+	"wirey/backend"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdate(t *testing.T) {
+	now := time.Now()
+	n := &Notifier{Watchdog: 30 * time.Second}
+
+	s := backend.Status{State: backend.StateJoining, LastActivity: now, NextActivity: time.Second}
+	assert.Equal(t, []string{"STATUS=joining the backend", "WATCHDOG=1"}, n.update(s, now))
+	assert.Equal(t, []string{"WATCHDOG=1"}, n.update(s, now), "the status did not change")
+
+	s.State = backend.StateApplied
+	s.LinkUp = true
+	s.Applied = make([]backend.Peer, 2)
+	assert.Equal(t, []string{"READY=1", "STATUS=applied, 2 peers applied", "WATCHDOG=1"}, n.update(s, now))
+
+	s.State = backend.StateDegraded
+	s.BackendError = assert.AnError
+	s.BackendErrorSince = now
+	states := n.update(s, now)
+	assert.Len(t, states, 2, "ready is only sent once")
+	assert.Contains(t, states[0], "STATUS=degraded, 2 peers applied, backend unreachable since")
+
+	assert.Empty(t, n.update(s, now.Add(time.Minute)), "the loop is late, the watchdog is not fed")
+}
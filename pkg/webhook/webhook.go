@@ -0,0 +1,254 @@
+// Package webhook posts the membership changes seen by a backend.Interface
+// to an http endpoint, signed with a shared secret.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"wirey/backend"
+
+	"github.com/cenkalti/backoff/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// Who reports a change
+const (
+	// ReportLeader only lets the node with the lowest public key report a
+	// change, every node agrees on it without talking to the others
+	ReportLeader = "leader"
+	// ReportEach lets every node report the changes it sees
+	ReportEach = "each"
+)
+
+// Request headers
+const (
+	// SignatureHeader is sha256=<hex hmac-sha256 of the body with the secret>
+	SignatureHeader = "X-Wirey-Signature"
+	// EventHeader is the type of the event
+	EventHeader = "X-Wirey-Event"
+	// DeliveryHeader identifies the change, it is the same on every node
+	// reporting it and on every retry
+	DeliveryHeader = "X-Wirey-Delivery"
+)
+
+// Defaults
+const (
+	DefaultTimeout        = 10 * time.Second
+	DefaultMaxElapsedTime = 15 * time.Minute
+	queueSize             = 100
+)
+
+const errUnknownReport = "unknown webhook report mode %q, available modes: [%s, %s]"
+
+// Payload is the body of the requests
+type Payload struct {
+	// ID is the same as the DeliveryHeader
+	ID string `json:"id"`
+	// Reporter is the public key of the node sending the request
+	Reporter string        `json:"reporter"`
+	Event    backend.Event `json:"event"`
+}
+
+// Webhook is a backend.Observer posting the PeerAdded, PeerRemoved and
+// PeerChanged events. The events of the first apply after wirey starts are
+// not changes and are not posted. Deliveries run in the background, with
+// retries.
+type Webhook struct {
+	URL    string
+	Secret []byte
+	// Report is ReportLeader or ReportEach
+	Report string
+	// LocalPublicKey identifies this node, to tell if it is the leader
+	LocalPublicKey []byte
+	Client         *http.Client
+	// MaxElapsedTime is how long a delivery is retried
+	MaxElapsedTime time.Duration
+
+	queueOnce sync.Once
+	queueCh   chan Payload
+	mu        sync.Mutex
+	// delivered is the id of the last change delivered for each peer
+	delivered map[string]string
+}
+
+// New returns a Webhook posting to url with the default settings
+func New(url string, secret []byte, report string, localPublicKey []byte) (*Webhook, error) {
+	if report != ReportLeader && report != ReportEach {
+		return nil, fmt.Errorf(errUnknownReport, report, ReportLeader, ReportEach)
+	}
+	return &Webhook{
+		URL:            url,
+		Secret:         secret,
+		Report:         report,
+		LocalPublicKey: localPublicKey,
+		Client:         &http.Client{Timeout: DefaultTimeout},
+		MaxElapsedTime: DefaultMaxElapsedTime,
+	}, nil
+}
+
+// OnEvent queues the membership changes this node reports
+func (w *Webhook) OnEvent(e backend.Event) {
+	switch e.Type {
+	case backend.EventPeerAdded, backend.EventPeerRemoved, backend.EventPeerChanged:
+	default:
+		return
+	}
+	if e.Initial || !w.reports(e.Peers) {
+		return
+	}
+
+	p := Payload{
+		ID:       DeliveryID(e),
+		Reporter: strings.TrimSpace(string(w.LocalPublicKey)),
+		Event:    e,
+	}
+	select {
+	case w.queue() <- p:
+	default:
+		log.Warnf("the webhook queue is full, dropping the %s event %s", e.Type, p.ID)
+	}
+}
+
+// reports tells if this node reports the changes of peers
+func (w *Webhook) reports(peers []backend.Peer) bool {
+	if w.Report == ReportEach {
+		return true
+	}
+	// static peers do not run wirey, they cannot report anything
+	for _, p := range peers {
+		if !p.Static && bytes.Compare(p.PublicKey, w.LocalPublicKey) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Run delivers the queued events until the context is cancelled
+func (w *Webhook) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-w.queue():
+			if w.isDelivered(p) {
+				log.Debugf("the %s event %s was already delivered", p.Event.Type, p.ID)
+				continue
+			}
+			if err := w.deliver(ctx, p); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Errorf("unable to deliver the %s event %s to the webhook: %s", p.Event.Type, p.ID, err.Error())
+				continue
+			}
+			w.setDelivered(p)
+		}
+	}
+}
+
+func (w *Webhook) queue() chan Payload {
+	w.queueOnce.Do(func() {
+		w.queueCh = make(chan Payload, queueSize)
+	})
+	return w.queueCh
+}
+
+// deliver posts p, retrying with an exponential backoff
+func (w *Webhook) deliver(ctx context.Context, p Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	exp := backoff.NewExponentialBackOff()
+	exp.MaxElapsedTime = w.MaxElapsedTime
+	return backoff.RetryNotify(func() error {
+		return w.post(ctx, p, body)
+	}, backoff.WithContext(exp, ctx), func(err error, next time.Duration) {
+		log.Warnf("webhook delivery failed: %s, retrying in %s", err.Error(), next)
+	})
+}
+
+func (w *Webhook) post(ctx context.Context, p Payload, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, p.Event.Type)
+	req.Header.Set(DeliveryHeader, p.ID)
+	if len(w.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("the webhook answered %s", resp.Status)
+	// the request will not get better, unless the receiver was busy
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return backoff.Permanent(err)
+	}
+	return err
+}
+
+// isDelivered tells if p is the last change delivered for its peer, e.g.
+// when the same peer set is applied again
+func (w *Webhook) isDelivered(p Payload) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.delivered[string(p.Event.Peer.PublicKey)] == p.ID
+}
+
+func (w *Webhook) setDelivered(p Payload) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.delivered == nil {
+		w.delivered = map[string]string{}
+	}
+	w.delivered[string(p.Event.Peer.PublicKey)] = p.ID
+}
+
+// DeliveryID identifies the change of an event: the same change seen by
+// different nodes has the same id, whatever the name of their device
+func DeliveryID(e backend.Event) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", e.Network, e.Type)
+	for _, p := range []*backend.Peer{e.Previous, e.Peer} {
+		if p != nil {
+			j, _ := json.Marshal(p)
+			h.Write(j)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Sign returns the value of the SignatureHeader for body
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify tells if signature is the one of body, for the receivers
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
@@ -0,0 +1,158 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"wirey/backend"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPeer(key string) backend.Peer {
+	ip := net.ParseIP("10.0.0.2")
+	return backend.Peer{PublicKey: []byte(key + "\n"), IP: &ip, Endpoint: "192.168.0.2:2345"}
+}
+
+func added(peer backend.Peer, peers ...backend.Peer) backend.Event {
+	return backend.Event{
+		Type:      backend.EventPeerAdded,
+		Interface: "wg0",
+		Peer:      &peer,
+		Peers:     append(peers, peer),
+		Diff:      backend.PeerDiff{Added: []backend.Peer{peer}},
+	}
+}
+
+// receiver records the requests, failing the first ones
+type receiver struct {
+	mutex    sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	body, _ := ioutil.ReadAll(req.Body)
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *receiver) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.requests)
+}
+
+func run(w *Webhook) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	r := &receiver{failures: 1}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	w, err := New(srv.URL, []byte("secret"), ReportEach, []byte("local\n"))
+	require.NoError(t, err)
+	stop := run(w)
+	defer stop()
+
+	e := added(testPeer("peer"))
+	w.OnEvent(e)
+	require.Eventually(t, func() bool { return r.count() == 2 }, 5*time.Second, 10*time.Millisecond, "the failed delivery is retried")
+
+	r.mutex.Lock()
+	req, body := r.requests[1], r.bodies[1]
+	r.mutex.Unlock()
+	assert.True(t, Verify([]byte("secret"), body, req.Header.Get(SignatureHeader)))
+	assert.Equal(t, backend.EventPeerAdded, req.Header.Get(EventHeader))
+	assert.Equal(t, DeliveryID(e), req.Header.Get(DeliveryHeader))
+
+	var p struct {
+		ID       string
+		Reporter string
+		Event    struct {
+			Type string
+			Peer struct{ PublicKey string }
+		}
+	}
+	require.NoError(t, json.Unmarshal(body, &p))
+	assert.Equal(t, DeliveryID(e), p.ID)
+	assert.Equal(t, "local", p.Reporter)
+	assert.Equal(t, "peer", p.Event.Peer.PublicKey)
+
+	// the same change is not delivered twice, a different one is
+	w.OnEvent(e)
+	removed := e
+	removed.Type = backend.EventPeerRemoved
+	w.OnEvent(removed)
+	require.Eventually(t, func() bool { return r.count() == 3 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, r.count())
+}
+
+func TestOnEventFilters(t *testing.T) {
+	w, err := New("http://127.0.0.1", nil, ReportLeader, []byte("b\n"))
+	require.NoError(t, err)
+
+	w.OnEvent(added(testPeer("c"), testPeer("d")))
+	assert.Len(t, w.queue(), 1, "the local node has the lowest key")
+	<-w.queue()
+
+	w.OnEvent(added(testPeer("c"), testPeer("a")))
+	assert.Len(t, w.queue(), 0, "another node has a lower key")
+
+	static := testPeer("a")
+	static.Static = true
+	w.OnEvent(added(testPeer("c"), static))
+	assert.Len(t, w.queue(), 1, "static peers are not leaders")
+	<-w.queue()
+
+	initial := added(testPeer("c"))
+	initial.Initial = true
+	w.OnEvent(initial)
+	w.OnEvent(backend.Event{Type: backend.EventPostUp})
+	assert.Len(t, w.queue(), 0)
+
+	_, err = New("http://127.0.0.1", nil, "some", nil)
+	assert.Error(t, err)
+}
+
+func TestPermanentFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	w, err := New(srv.URL, nil, ReportEach, []byte("local\n"))
+	require.NoError(t, err)
+	start := time.Now()
+	assert.Error(t, w.deliver(context.Background(), Payload{Event: added(testPeer("peer"))}))
+	assert.True(t, time.Since(start) < time.Second, "a client error is not retried")
+}
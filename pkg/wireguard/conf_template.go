@@ -8,5 +8,5 @@ PrivateKey = {{ .Interface.PrivateKey }}
 [Peer]
 PublicKey = {{ .PublicKey }}
 AllowedIPs = {{ .AllowedIPs }}
-Endpoint = {{ .Endpoint }}
+{{ if .Endpoint }}Endpoint = {{ .Endpoint }}{{ end }}
 {{ end }}`
@@ -0,0 +1,125 @@
+package wireguard
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Device is the state of a wireguard interface as reported by `wg show <ifname> dump`
+type Device struct {
+	PrivateKey string
+	PublicKey  string
+	ListenPort int
+	Peers      []DevicePeer
+}
+
+// DevicePeer is a peer configured on a Device
+type DevicePeer struct {
+	PublicKey           string
+	Endpoint            string
+	AllowedIPs          []string
+	LatestHandshake     time.Time
+	TransferRx          int64
+	TransferTx          int64
+	PersistentKeepalive int
+}
+
+// Show returns the current state of the ifname device
+func Show(ifname string) (*Device, error) {
+	result, err := wg(nil, "show", ifname, "dump")
+	if err != nil {
+		return nil, fmt.Errorf("error reading the wireguard device %s: %s", ifname, err.Error())
+	}
+	return ParseDump(bytes.NewReader(result))
+}
+
+// ParseDump parses the output of `wg show <ifname> dump`: the interface on
+// the first line and then one peer per line, with tab separated fields.
+func ParseDump(r io.Reader) (*Device, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("empty wireguard dump")
+	}
+
+	// private-key public-key listen-port fwmark
+	fields := strings.Split(scanner.Text(), "\t")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("unexpected wireguard dump interface line with %d fields", len(fields))
+	}
+	port, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("unexpected wireguard dump listen port: %s", err.Error())
+	}
+	device := &Device{
+		PrivateKey: dumpValue(fields[0]),
+		PublicKey:  dumpValue(fields[1]),
+		ListenPort: port,
+		Peers:      []DevicePeer{},
+	}
+
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		peer, err := parseDumpPeer(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		device.Peers = append(device.Peers, peer)
+	}
+	return device, scanner.Err()
+}
+
+// public-key preshared-key endpoint allowed-ips latest-handshake transfer-rx transfer-tx persistent-keepalive
+func parseDumpPeer(line string) (DevicePeer, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 8 {
+		return DevicePeer{}, fmt.Errorf("unexpected wireguard dump peer line with %d fields", len(fields))
+	}
+
+	numbers := make([]int64, 3)
+	for i, f := range fields[4:7] {
+		n, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return DevicePeer{}, fmt.Errorf("unexpected wireguard dump peer field: %s", err.Error())
+		}
+		numbers[i] = n
+	}
+
+	peer := DevicePeer{
+		PublicKey:  fields[0],
+		Endpoint:   dumpValue(fields[2]),
+		AllowedIPs: []string{},
+		TransferRx: numbers[1],
+		TransferTx: numbers[2],
+	}
+	if numbers[0] > 0 {
+		peer.LatestHandshake = time.Unix(numbers[0], 0)
+	}
+	if allowedIPs := dumpValue(fields[3]); allowedIPs != "" {
+		peer.AllowedIPs = strings.Split(allowedIPs, ",")
+	}
+	if keepalive := fields[7]; keepalive != "off" {
+		n, err := strconv.Atoi(keepalive)
+		if err != nil {
+			return DevicePeer{}, fmt.Errorf("unexpected wireguard dump persistent keepalive: %s", err.Error())
+		}
+		peer.PersistentKeepalive = n
+	}
+	return peer, nil
+}
+
+func dumpValue(v string) string {
+	if v == "(none)" {
+		return ""
+	}
+	return v
+}
@@ -0,0 +1,89 @@
+package wireguard
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDump = "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=\tRg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=\t2345\toff\n" +
+	"nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=\t(none)\t172.31.23.162:2345\t10.0.0.2/32,10.10.0.0/16\t1571234567\t820\t764\toff\n" +
+	"59Je0kMsYkWkQ52Rt7o9Ss60QP3fTcoTQgJgsWDW/QQ=\t(none)\t(none)\t10.0.0.3/32\t0\t0\t0\t25\n"
+
+func TestParseDump(t *testing.T) {
+	device, err := ParseDump(strings.NewReader(testDump))
+	require.NoError(t, err)
+
+	assert.Equal(t, "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=", device.PrivateKey)
+	assert.Equal(t, "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=", device.PublicKey)
+	assert.Equal(t, 2345, device.ListenPort)
+	assert.Equal(t, []DevicePeer{
+		{
+			PublicKey:       "nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=",
+			Endpoint:        "172.31.23.162:2345",
+			AllowedIPs:      []string{"10.0.0.2/32", "10.10.0.0/16"},
+			LatestHandshake: time.Unix(1571234567, 0),
+			TransferRx:      820,
+			TransferTx:      764,
+		},
+		{
+			PublicKey:           "59Je0kMsYkWkQ52Rt7o9Ss60QP3fTcoTQgJgsWDW/QQ=",
+			AllowedIPs:          []string{"10.0.0.3/32"},
+			PersistentKeepalive: 25,
+		},
+	}, device.Peers)
+}
+
+func TestParseDumpInvalid(t *testing.T) {
+	_, err := ParseDump(strings.NewReader(""))
+	assert.Error(t, err)
+
+	_, err = ParseDump(strings.NewReader("key\tkey\tnotaport\toff\n"))
+	assert.Error(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	device, err := ParseDump(strings.NewReader(testDump))
+	require.NoError(t, err)
+
+	diff := Diff(device, Configuration{
+		Interface: Interface{ListenPort: 2346},
+		Peers: []Peer{
+			{
+				// same peer, allowed ips in another order
+				PublicKey:  "nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=\n",
+				AllowedIPs: "10.10.0.0/16,10.0.0.2/32",
+				Endpoint:   "172.31.23.162:2345",
+			},
+			{
+				PublicKey:  "59Je0kMsYkWkQ52Rt7o9Ss60QP3fTcoTQgJgsWDW/QQ=\n",
+				AllowedIPs: "10.0.0.3/32",
+				Endpoint:   "172.31.23.163:2345",
+			},
+			{
+				PublicKey:  "T053azhMRW1sV2tQbjVISUgycnZtQWt5bDdKN3hJL3I=\n",
+				AllowedIPs: "10.0.0.4/32",
+				Endpoint:   "172.31.23.164:2345",
+			},
+		},
+	})
+
+	assert.False(t, diff.Empty())
+	assert.Equal(t, 2345, diff.CurrentListenPort)
+	assert.Equal(t, 2346, diff.DesiredListenPort)
+	require.Len(t, diff.Added, 1)
+	assert.Equal(t, "T053azhMRW1sV2tQbjVISUgycnZtQWt5bDdKN3hJL3I=", diff.Added[0].PublicKey)
+	assert.Empty(t, diff.Removed)
+	require.Len(t, diff.Changed, 1)
+	assert.Equal(t, "", diff.Changed[0].Current.Endpoint)
+	assert.Equal(t, "172.31.23.163:2345", diff.Changed[0].Desired.Endpoint)
+
+	diff = Diff(device, Configuration{Interface: Interface{ListenPort: 2345}})
+	assert.Len(t, diff.Removed, 2)
+
+	diff = Diff(nil, Configuration{})
+	assert.True(t, diff.Empty())
+}
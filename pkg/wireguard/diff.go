@@ -0,0 +1,97 @@
+package wireguard
+
+import (
+	"sort"
+	"strings"
+)
+
+// ConfigurationDiff is what changes on a device when a configuration is applied
+type ConfigurationDiff struct {
+	CurrentListenPort int
+	DesiredListenPort int
+	Added             []Peer
+	Removed           []Peer
+	Changed           []PeerChange
+}
+
+// PeerChange is a peer present on both sides with different settings
+type PeerChange struct {
+	Current Peer
+	Desired Peer
+}
+
+// Empty tells if applying the configuration would change nothing
+func (d ConfigurationDiff) Empty() bool {
+	return d.CurrentListenPort == d.DesiredListenPort &&
+		len(d.Added) == 0 &&
+		len(d.Removed) == 0 &&
+		len(d.Changed) == 0
+}
+
+// Diff compares the current state of a device, nil if it does not exist,
+// with the desired configuration. Peers are matched by public key.
+func Diff(current *Device, desired Configuration) ConfigurationDiff {
+	diff := ConfigurationDiff{
+		DesiredListenPort: desired.Interface.ListenPort,
+		Added:             []Peer{},
+		Removed:           []Peer{},
+		Changed:           []PeerChange{},
+	}
+
+	currentPeers := map[string]Peer{}
+	if current != nil {
+		diff.CurrentListenPort = current.ListenPort
+		for _, p := range current.Peers {
+			currentPeers[p.PublicKey] = Peer{
+				PublicKey:  p.PublicKey,
+				AllowedIPs: normalizeAllowedIPs(strings.Join(p.AllowedIPs, ",")),
+				Endpoint:   p.Endpoint,
+			}
+		}
+	}
+
+	desiredPeers := map[string]bool{}
+	for _, p := range desired.Peers {
+		d := Peer{
+			PublicKey:  strings.TrimSpace(p.PublicKey),
+			AllowedIPs: normalizeAllowedIPs(p.AllowedIPs),
+			Endpoint:   p.Endpoint,
+		}
+		desiredPeers[d.PublicKey] = true
+
+		c, ok := currentPeers[d.PublicKey]
+		if !ok {
+			diff.Added = append(diff.Added, d)
+			continue
+		}
+		if c != d {
+			diff.Changed = append(diff.Changed, PeerChange{Current: c, Desired: d})
+		}
+	}
+
+	for key, c := range currentPeers {
+		if !desiredPeers[key] {
+			diff.Removed = append(diff.Removed, c)
+		}
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].PublicKey < diff.Added[j].PublicKey })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].PublicKey < diff.Removed[j].PublicKey })
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].Desired.PublicKey < diff.Changed[j].Desired.PublicKey
+	})
+	return diff
+}
+
+// normalizeAllowedIPs sorts a comma separated list of allowed ips, so that
+// the same set always compares equal
+func normalizeAllowedIPs(allowedIPs string) string {
+	ips := []string{}
+	for _, ip := range strings.Split(allowedIPs, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Strings(ips)
+	return strings.Join(ips, ",")
+}
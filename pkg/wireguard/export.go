@@ -0,0 +1,110 @@
+package wireguard
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Export formats
+const (
+	FormatWgQuick   = "wg-quick"
+	FormatNetworkd  = "networkd"
+	FormatNMKeyfile = "nm-keyfile"
+)
+
+// ExportOptions are the interface settings that `wg setconf` does not handle
+type ExportOptions struct {
+	// Name is the name of the interface
+	Name string
+	// Address is the address of the interface, in CIDR notation
+	Address string
+	DNS     []string
+	MTU     int
+	// PersistentKeepalive, in seconds, is set on every peer when not 0
+	PersistentKeepalive int
+}
+
+// NetworkdGroup is the group systemd-networkd runs as
+const NetworkdGroup = "systemd-network"
+
+// File is a configuration file produced by Export
+type File struct {
+	Name string
+	Mode os.FileMode
+	// Group, when set, must own the file for the tool to read it
+	Group   string
+	Content []byte
+}
+
+// Export renders a full configuration, with the interface settings, in a
+// format understood by other tools. Some formats need more than one file.
+func Export(format string, conf Configuration, opts ExportOptions) ([]File, error) {
+	data := struct {
+		Configuration
+		Options ExportOptions
+	}{
+		Configuration: trimKeys(conf),
+		Options:       opts,
+	}
+
+	type file struct {
+		suffix string
+		mode   os.FileMode
+		group  string
+		tmpl   string
+	}
+	var files []file
+	switch format {
+	case FormatWgQuick:
+		files = []file{{".conf", 0600, "", wgQuickTemplate}}
+	case FormatNetworkd:
+		// the netdev holds the private key, systemd-networkd only reads it
+		// when the file belongs to its group
+		files = []file{{".netdev", 0640, NetworkdGroup, networkdNetdevTemplate}, {".network", 0644, "", networkdNetworkTemplate}}
+	case FormatNMKeyfile:
+		files = []file{{".nmconnection", 0600, "", nmKeyfileTemplate}}
+	default:
+		return nil, fmt.Errorf("unknown export format %q, available formats: [%s, %s, %s]", format, FormatWgQuick, FormatNetworkd, FormatNMKeyfile)
+	}
+
+	funcs := template.FuncMap{
+		"join": strings.Join,
+		"nmList": func(list string) string {
+			return strings.Replace(list, ",", ";", -1) + ";"
+		},
+	}
+
+	result := []File{}
+	for _, f := range files {
+		t := template.Must(template.New(format).Funcs(funcs).Parse(f.tmpl))
+		buf := &bytes.Buffer{}
+		if err := t.Execute(buf, data); err != nil {
+			return nil, err
+		}
+		result = append(result, File{
+			Name:    opts.Name + f.suffix,
+			Mode:    f.mode,
+			Group:   f.group,
+			Content: buf.Bytes(),
+		})
+	}
+	return result, nil
+}
+
+// trimKeys removes the newline `wg genkey` and `wg pubkey` leave at the end
+// of the keys, the exported formats need them on a single line
+func trimKeys(conf Configuration) Configuration {
+	trimmed := Configuration{
+		Interface: conf.Interface,
+		Peers:     []Peer{},
+	}
+	trimmed.Interface.PrivateKey = strings.TrimSpace(conf.Interface.PrivateKey)
+	for _, p := range conf.Peers {
+		p.PublicKey = strings.TrimSpace(p.PublicKey)
+		trimmed.Peers = append(trimmed.Peers, p)
+	}
+	return trimmed
+}
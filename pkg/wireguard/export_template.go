@@ -0,0 +1,93 @@
+package wireguard
+
+const wgQuickTemplate = `[Interface]
+Address = {{ .Options.Address }}
+{{- if .Interface.ListenPort }}
+ListenPort = {{ .Interface.ListenPort }}
+{{- end }}
+PrivateKey = {{ .Interface.PrivateKey }}
+{{- if .Options.DNS }}
+DNS = {{ join .Options.DNS ", " }}
+{{- end }}
+{{- if .Options.MTU }}
+MTU = {{ .Options.MTU }}
+{{- end }}
+{{ range .Peers }}
+[Peer]
+PublicKey = {{ .PublicKey }}
+AllowedIPs = {{ .AllowedIPs }}
+{{- if .Endpoint }}
+Endpoint = {{ .Endpoint }}
+{{- end }}
+{{- if $.Options.PersistentKeepalive }}
+PersistentKeepalive = {{ $.Options.PersistentKeepalive }}
+{{- end }}
+{{ end }}`
+
+const networkdNetdevTemplate = `[NetDev]
+Name={{ .Options.Name }}
+Kind=wireguard
+{{- if .Options.MTU }}
+MTUBytes={{ .Options.MTU }}
+{{- end }}
+
+[WireGuard]
+PrivateKey={{ .Interface.PrivateKey }}
+{{- if .Interface.ListenPort }}
+ListenPort={{ .Interface.ListenPort }}
+{{- end }}
+{{ range .Peers }}
+[WireGuardPeer]
+PublicKey={{ .PublicKey }}
+AllowedIPs={{ .AllowedIPs }}
+{{- if .Endpoint }}
+Endpoint={{ .Endpoint }}
+{{- end }}
+{{- if $.Options.PersistentKeepalive }}
+PersistentKeepaliveSec={{ $.Options.PersistentKeepalive }}
+{{- end }}
+{{ end }}`
+
+const networkdNetworkTemplate = `[Match]
+Name={{ .Options.Name }}
+
+[Network]
+Address={{ .Options.Address }}
+{{- range .Options.DNS }}
+DNS={{ . }}
+{{- end }}
+`
+
+const nmKeyfileTemplate = `[connection]
+id={{ .Options.Name }}
+type=wireguard
+interface-name={{ .Options.Name }}
+
+[wireguard]
+{{- if .Interface.ListenPort }}
+listen-port={{ .Interface.ListenPort }}
+{{- end }}
+private-key={{ .Interface.PrivateKey }}
+{{- if .Options.MTU }}
+mtu={{ .Options.MTU }}
+{{- end }}
+{{ range .Peers }}
+[wireguard-peer.{{ .PublicKey }}]
+{{- if .Endpoint }}
+endpoint={{ .Endpoint }}
+{{- end }}
+{{- if $.Options.PersistentKeepalive }}
+persistent-keepalive={{ $.Options.PersistentKeepalive }}
+{{- end }}
+allowed-ips={{ nmList .AllowedIPs }}
+{{ end }}
+[ipv4]
+address1={{ .Options.Address }}
+{{- if .Options.DNS }}
+dns={{ join .Options.DNS ";" }};
+{{- end }}
+method=manual
+
+[ipv6]
+method=disabled
+`
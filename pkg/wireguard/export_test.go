@@ -0,0 +1,130 @@
+package wireguard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var exportConf = Configuration{
+	Interface: Interface{
+		ListenPort: 49082,
+		PrivateKey: "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=\n",
+	},
+	Peers: []Peer{
+		{
+			PublicKey:  "Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=\n",
+			AllowedIPs: "10.0.0.1/32,10.10.0.0/16",
+			Endpoint:   "172.31.23.163:50113",
+		},
+		{
+			PublicKey:  "nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=\n",
+			AllowedIPs: "10.0.0.2/32",
+		},
+	},
+}
+
+var exportOptions = ExportOptions{
+	Name:    "wg0",
+	Address: "10.0.0.3/24",
+	DNS:     []string{"10.0.0.53", "1.1.1.1"},
+	MTU:     1420,
+}
+
+func TestExportWgQuick(t *testing.T) {
+	files, err := Export(FormatWgQuick, exportConf, exportOptions)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	assert.Equal(t, "wg0.conf", files[0].Name)
+	assert.Equal(t, `[Interface]
+Address = 10.0.0.3/24
+ListenPort = 49082
+PrivateKey = iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=
+DNS = 10.0.0.53, 1.1.1.1
+MTU = 1420
+
+[Peer]
+PublicKey = Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=
+AllowedIPs = 10.0.0.1/32,10.10.0.0/16
+Endpoint = 172.31.23.163:50113
+
+[Peer]
+PublicKey = nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=
+AllowedIPs = 10.0.0.2/32
+`, string(files[0].Content))
+}
+
+func TestExportNetworkd(t *testing.T) {
+	files, err := Export(FormatNetworkd, exportConf, exportOptions)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	assert.Equal(t, "wg0.netdev", files[0].Name)
+	assert.Equal(t, NetworkdGroup, files[0].Group, "networkd reads the private key through its group")
+	assert.Equal(t, `[NetDev]
+Name=wg0
+Kind=wireguard
+MTUBytes=1420
+
+[WireGuard]
+PrivateKey=iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=
+ListenPort=49082
+
+[WireGuardPeer]
+PublicKey=Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=
+AllowedIPs=10.0.0.1/32,10.10.0.0/16
+Endpoint=172.31.23.163:50113
+
+[WireGuardPeer]
+PublicKey=nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=
+AllowedIPs=10.0.0.2/32
+`, string(files[0].Content))
+
+	assert.Equal(t, "wg0.network", files[1].Name)
+	assert.Equal(t, `[Match]
+Name=wg0
+
+[Network]
+Address=10.0.0.3/24
+DNS=10.0.0.53
+DNS=1.1.1.1
+`, string(files[1].Content))
+}
+
+func TestExportNMKeyfile(t *testing.T) {
+	files, err := Export(FormatNMKeyfile, exportConf, ExportOptions{Name: "wg0", Address: "10.0.0.3/24"})
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	assert.Equal(t, "wg0.nmconnection", files[0].Name)
+	assert.Equal(t, `[connection]
+id=wg0
+type=wireguard
+interface-name=wg0
+
+[wireguard]
+listen-port=49082
+private-key=iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=
+
+[wireguard-peer.Rg3XQfzH0LWuUBy/MHZxMcCLxiMaE5BS1hY/pncQ0G4=]
+endpoint=172.31.23.163:50113
+allowed-ips=10.0.0.1/32;10.10.0.0/16;
+
+[wireguard-peer.nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=]
+allowed-ips=10.0.0.2/32;
+
+[ipv4]
+address1=10.0.0.3/24
+method=manual
+
+[ipv6]
+method=disabled
+`, string(files[0].Content))
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	_, err := Export("ini", exportConf, exportOptions)
+	assert.Error(t, err)
+}
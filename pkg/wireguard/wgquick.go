@@ -0,0 +1,93 @@
+package wireguard
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ParseWgQuick parses a wg-quick configuration file. The settings that only
+// wg-quick understands (Address, DNS, MTU, PostUp...) are ignored, as well
+// as the preshared keys.
+func ParseWgQuick(r io.Reader) (*Device, error) {
+	device := &Device{Peers: []DevicePeer{}}
+	var peer *DevicePeer
+	section := ""
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			switch section {
+			case "interface":
+			case "peer":
+				device.Peers = append(device.Peers, DevicePeer{AllowedIPs: []string{}})
+				peer = &device.Peers[len(device.Peers)-1]
+			default:
+				return nil, fmt.Errorf("line %d: unknown section [%s]", n, section)
+			}
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.TrimSpace(kv[1])
+
+		var err error
+		switch section {
+		case "interface":
+			switch key {
+			case "privatekey":
+				device.PrivateKey = value
+			case "listenport":
+				device.ListenPort, err = strconv.Atoi(value)
+			}
+		case "peer":
+			switch key {
+			case "publickey":
+				peer.PublicKey = value
+			case "endpoint":
+				peer.Endpoint = value
+			case "allowedips":
+				for _, ip := range strings.Split(value, ",") {
+					if ip = strings.TrimSpace(ip); ip != "" {
+						peer.AllowedIPs = append(peer.AllowedIPs, ip)
+					}
+				}
+			case "persistentkeepalive":
+				if value != "off" {
+					peer.PersistentKeepalive, err = strconv.Atoi(value)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("line %d: %s outside of a section", n, kv[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s: %s", n, kv[0], err.Error())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, p := range device.Peers {
+		if p.PublicKey == "" {
+			return nil, fmt.Errorf("a [Peer] section has no PublicKey")
+		}
+	}
+	return device, nil
+}
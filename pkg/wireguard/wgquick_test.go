@@ -0,0 +1,61 @@
+package wireguard
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testWgQuick = `# hand made, do not touch
+[Interface]
+Address = 10.0.0.1/24
+ListenPort = 51820
+PrivateKey = iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+
+[Peer]
+# laptop
+PublicKey = nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=
+AllowedIPs = 10.0.0.2/32, 10.10.0.0/16
+Endpoint = 172.31.23.162:2345
+
+[peer]
+publickey = 59Je0kMsYkWkQ52Rt7o9Ss60QP3fTcoTQgJgsWDW/QQ=
+AllowedIPs = 10.0.0.3/32
+PersistentKeepalive = 25
+`
+
+func TestParseWgQuick(t *testing.T) {
+	device, err := ParseWgQuick(strings.NewReader(testWgQuick))
+	require.NoError(t, err)
+
+	assert.Equal(t, "iOIMgrmMHt/L/GT+Fw2DruosUXDlBgSclXo52S//41k=", device.PrivateKey)
+	assert.Equal(t, 51820, device.ListenPort)
+	assert.Equal(t, []DevicePeer{
+		{
+			PublicKey:  "nAMY8gSy32B7rLV8kiLq4GKJBbYT3amT+c0DI5vikik=",
+			Endpoint:   "172.31.23.162:2345",
+			AllowedIPs: []string{"10.0.0.2/32", "10.10.0.0/16"},
+		},
+		{
+			PublicKey:           "59Je0kMsYkWkQ52Rt7o9Ss60QP3fTcoTQgJgsWDW/QQ=",
+			AllowedIPs:          []string{"10.0.0.3/32"},
+			PersistentKeepalive: 25,
+		},
+	}, device.Peers)
+}
+
+func TestParseWgQuickInvalid(t *testing.T) {
+	for _, conf := range []string{
+		"ListenPort = 51820\n",
+		"[Interface]\nListenPort = port\n",
+		"[Interface]\nListenPort\n",
+		"[Route]\n",
+		"[Peer]\nAllowedIPs = 10.0.0.2/32\n",
+	} {
+		_, err := ParseWgQuick(strings.NewReader(conf))
+		assert.Error(t, err, conf)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strings"
 	"text/template"
 )
 
@@ -81,7 +82,7 @@ func SetConf(ifname string, conf Configuration) ([]byte, error) {
 		return nil, err
 	}
 
-	result, err := wg(nil, "setconf", "wg0", cfile.Name())
+	result, err := wg(nil, "setconf", ifname, cfile.Name())
 
 	if err != nil {
 		return nil, fmt.Errorf("error setting the configuration for wireguard: %s", err.Error())
@@ -89,6 +90,30 @@ func SetConf(ifname string, conf Configuration) ([]byte, error) {
 	return result, nil
 }
 
+// SetPeers adds or updates the set peers and removes the peers with the
+// remove public keys, the other peers and their sessions are untouched
+func SetPeers(ifname string, set []Peer, remove []string) ([]byte, error) {
+	result, err := wg(nil, setPeersArgs(ifname, set, remove)...)
+	if err != nil {
+		return nil, fmt.Errorf("error updating the wireguard peers: %s", err.Error())
+	}
+	return result, nil
+}
+
+func setPeersArgs(ifname string, set []Peer, remove []string) []string {
+	args := []string{"set", ifname}
+	for _, p := range set {
+		args = append(args, "peer", strings.TrimSpace(p.PublicKey), "allowed-ips", p.AllowedIPs)
+		if p.Endpoint != "" {
+			args = append(args, "endpoint", p.Endpoint)
+		}
+	}
+	for _, key := range remove {
+		args = append(args, "peer", strings.TrimSpace(key), "remove")
+	}
+	return args
+}
+
 func RenderConfiguration(conf Configuration) ([]byte, error) {
 	t := template.Must(template.New("config").Parse(confTemplate))
 	buf := &bytes.Buffer{}
@@ -50,3 +50,16 @@ Endpoint = 172.31.23.162:43043
 
 	assert.Equal(t, expected, string(rendered))
 }
+
+func TestSetPeersArgs(t *testing.T) {
+	args := setPeersArgs("wg0", []Peer{
+		{PublicKey: "key1\n", AllowedIPs: "10.0.0.1/32", Endpoint: "192.168.0.1:2345"},
+		{PublicKey: "key2", AllowedIPs: "10.0.0.2/32,10.1.0.0/16"},
+	}, []string{"key3\n"})
+	assert.Equal(t, []string{
+		"set", "wg0",
+		"peer", "key1", "allowed-ips", "10.0.0.1/32", "endpoint", "192.168.0.1:2345",
+		"peer", "key2", "allowed-ips", "10.0.0.2/32,10.1.0.0/16",
+		"peer", "key3", "remove",
+	}, args)
+}
@@ -0,0 +1,64 @@
+#!/usr/bin/env bash
+# Runs three wirey nodes on this machine, each one with its interface in
+# its own network namespace, using the example http server as backend.
+# The UDP sockets of all the nodes stay in the current namespace and talk
+# over the loopback, so no virtual machine is needed.
+#
+# Requires root, the wg tools and either the wireguard kernel module or
+# wireguard-go.
+set -euo pipefail
+
+NODES=3
+WORKDIR=$(mktemp -d /tmp/wirey-netns.XXXXXX)
+PIDS=()
+
+cleanup() {
+	for pid in "${PIDS[@]}"; do
+		kill "${pid}" 2>/dev/null || true
+	done
+	wait 2>/dev/null || true
+	for n in $(seq 1 ${NODES}); do
+		ip netns del "wirey-${n}" 2>/dev/null || true
+	done
+	rm -rf "${WORKDIR}"
+}
+trap cleanup EXIT
+
+go build -o "${WORKDIR}/wirey" ./cmd/wirey
+go build -o "${WORKDIR}/httpbackend" ./examples/httpbackend
+
+"${WORKDIR}/httpbackend" &
+PIDS+=($!)
+
+for n in $(seq 1 ${NODES}); do
+	ip netns add "wirey-${n}"
+	ip -n "wirey-${n}" link set lo up
+	mkdir -p "${WORKDIR}/${n}"
+	"${WORKDIR}/wirey" \
+		--http http://127.0.0.1:8080 \
+		--httpbasicauth time:series \
+		--netns "wirey-${n}" \
+		--endpoint 127.0.0.1 \
+		--endpoint-port "$((2344 + n))" \
+		--ipaddr "172.30.0.${n}" \
+		--privatekeypath "${WORKDIR}/${n}/privkey" \
+		--peerdiscoveryttl 2s \
+		> "${WORKDIR}/${n}/wirey.log" 2>&1 &
+	PIDS+=($!)
+done
+
+for n in $(seq 1 ${NODES}); do
+	for peer in $(seq 1 ${NODES}); do
+		[ "${n}" = "${peer}" ] && continue
+		for attempt in $(seq 1 30); do
+			if ip netns exec "wirey-${n}" ping -c 1 -W 1 "172.30.0.${peer}" > /dev/null; then
+				echo "wirey-${n} -> 172.30.0.${peer} ok"
+				continue 2
+			fi
+			sleep 1
+		done
+		echo "wirey-${n} cannot reach 172.30.0.${peer}"
+		cat "${WORKDIR}/${n}/wirey.log"
+		exit 1
+	done
+done